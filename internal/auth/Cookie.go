@@ -1,6 +1,9 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"log"
 	"net/http"
 	"time"
@@ -17,7 +20,34 @@ func GenerateSessionID() string {
 	return id.String()
 }
 
-func setSessionCookie(w http.ResponseWriter, sessionID string, expireAt time.Time) {
+// GenerateCSRFToken returns the random token guarding the forms of a session
+func GenerateCSRFToken() string {
+	return randomToken()
+}
+
+// randomToken returns 32 random bytes in hex, or "" if the system has no randomness
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Token generation error: %v", err)
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// hashToken is what the database keeps of a token mailed to a user
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// setSessionCookie hands the session to the browser. Only remembered sessions
+// get an expiry, the others end with the browser while the server still
+// expires them after sessionTTL of inactivity.
+func setSessionCookie(w http.ResponseWriter, sessionID string, expireAt time.Time, remember bool) {
+	if !remember {
+		expireAt = time.Time{}
+	}
 	http.SetCookie(w, &http.Cookie{
 		Name:     SessionCookieName,
 		Value:    sessionID,
@@ -28,3 +58,17 @@ func setSessionCookie(w http.ResponseWriter, sessionID string, expireAt time.Tim
 		Path:     "/",
 	})
 }
+
+// expireSessionCookie tells the browser to drop the session cookie
+func expireSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Now().Add(-1 * time.Hour),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   *secureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
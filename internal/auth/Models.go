@@ -3,6 +3,8 @@ package auth
 import (
 	"flag"
 	"time"
+
+	"forum/internal/store"
 )
 
 type contextKey string
@@ -12,13 +14,19 @@ const (
 	UserKey           contextKey = "userID"
 )
 
-var secureCookie = flag.Bool("secure-cookie01", false, "Set secure cookie flag")
+var (
+	secureCookie = flag.Bool("secure-cookie01", false, "Set secure cookie flag")
+	sessionTTL   = flag.Duration("session-ttl", 24*time.Hour, "Inactivity after which a session expires")
+	rememberTTL  = flag.Duration("remember-ttl", 30*24*time.Hour, "Inactivity after which a remembered session expires")
+	baseURL      = flag.String("base-url", "http://localhost:8080", "Public URL of the forum, used in the links of mails")
+)
 
-type users struct {
-	userID     int
-	storedHash string
-	dbEmail    string
-	username   string
+// sessionLifetime is how long a session lives past its last activity
+func sessionLifetime(remember bool) time.Duration {
+	if remember {
+		return *rememberTTL
+	}
+	return *sessionTTL
 }
 
 type Credentials struct {
@@ -31,16 +39,28 @@ type Error struct {
 	Username string
 	Email    string
 	Password string
+	Form     string // not tied to a field, like a lockout
 }
 
-type Sessions struct {
-	UserID    int
-	ExpiresAt time.Time
-	Username  string
+type ContextUser struct {
+	LoggedIn      bool
+	UserID        int
+	Username      string
+	EmailVerified bool
+	Role          string
+	SessionID     string
+	CSRFToken     string
+	// set when the request is authenticated by a personal access token
+	TokenID int
+	Scopes  []string
 }
 
-type ContextUser struct {
-	LoggedIn bool
-	UserID   int
-	Username string
+// HasRole tells whether the user is logged in with at least that role
+func (u ContextUser) HasRole(role string) bool {
+	return u.LoggedIn && store.RoleAtLeast(u.Role, role)
+}
+
+// CanModerate tells whether the user may change the posts and comments of others
+func (u ContextUser) CanModerate() bool {
+	return u.HasRole(store.RoleModerator)
 }
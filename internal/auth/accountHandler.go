@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+
+	db "forum/internal/database"
+	"forum/internal/store"
+)
+
+// sessionView is a session as listed on the account page, Ref identifies it in
+// the revoke form without giving away the session id, which is the cookie value
+type sessionView struct {
+	store.Session
+	Ref     string
+	Current bool
+}
+
+// sessionRef derives the public reference of a session
+func sessionRef(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// providerView is a login provider as listed on the account page
+type providerView struct {
+	Name   string
+	Title  string
+	Linked bool
+	Email  string
+}
+
+// AccountHandler shows the email status, 2FA status, linked accounts, active
+// sessions and moderator warnings of the current user
+func (h *Handler) AccountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(UserKey).(ContextUser)
+
+	user, err := h.Users.GetByID(userData.UserID)
+	if err != nil {
+		log.Println("Error loading user:", err)
+		db.HandleError(w, http.StatusInternalServerError, "Error loading account")
+		return
+	}
+
+	totp, err := h.TwoFactor.Get(userData.UserID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		db.HandleError(w, http.StatusInternalServerError, "Error loading account")
+		return
+	}
+	twoFactor := err == nil && totp.EnabledAt != nil
+
+	identities, err := h.Identities.ListByUser(userData.UserID)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Error loading account")
+		return
+	}
+	providers := make([]providerView, len(h.Providers))
+	for i, p := range h.Providers {
+		providers[i] = providerView{Name: p.Name(), Title: p.Title()}
+		for _, identity := range identities {
+			if identity.Provider == p.Name() {
+				providers[i].Linked, providers[i].Email = true, identity.Email
+			}
+		}
+	}
+
+	warnings, err := h.Moderation.ListWarnings(userData.UserID)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Error loading account")
+		return
+	}
+
+	sessions, err := h.Sessions.ListByUser(userData.UserID)
+	if err != nil {
+		log.Println("Error loading sessions:", err)
+		db.HandleError(w, http.StatusInternalServerError, "Error loading sessions")
+		return
+	}
+
+	views := make([]sessionView, len(sessions))
+	for i, s := range sessions {
+		views[i] = sessionView{Session: s, Ref: sessionRef(s.ID), Current: s.ID == userData.SessionID}
+	}
+
+	db.RenderTemplate(w, "account", map[string]interface{}{
+		"Title":            "Account",
+		"LoggedIn":         userData.LoggedIn,
+		"Username":         userData.Username,
+		"Sessions":         views,
+		"User":             user,
+		"TwoFactor":        twoFactor,
+		"Providers":        providers,
+		"Warnings":         warnings,
+		"IsAdmin":          userData.HasRole(store.RoleAdmin),
+		"CanModerate":      userData.CanModerate(),
+		"VerificationSent": r.URL.Query().Get("verification") == "sent",
+	})
+}
+
+// RevokeSessionHandler ends one of the sessions of the current user
+func (h *Handler) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(UserKey).(ContextUser)
+
+	sessions, err := h.Sessions.ListByUser(userData.UserID)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ref := r.FormValue("session")
+	for _, s := range sessions {
+		if sessionRef(s.ID) != ref {
+			continue
+		}
+		if err := h.Sessions.Delete(s.ID); err != nil {
+			db.HandleError(w, http.StatusInternalServerError, "Failed to revoke session")
+			return
+		}
+		// revoking the current session is a logout
+		if s.ID == userData.SessionID {
+			expireSessionCookie(w)
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
+	db.HandleError(w, http.StatusNotFound, "Session not found")
+}
+
+// LogoutOthersHandler ends every session of the current user but this one
+func (h *Handler) LogoutOthersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(UserKey).(ContextUser)
+
+	if err := h.Sessions.DeleteOthers(userData.UserID, userData.SessionID); err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Failed to log out other sessions")
+		return
+	}
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	db "forum/internal/database"
+	"forum/internal/store"
+)
+
+// roles is the order the role picker lists them in
+var roles = []string{store.RoleUser, store.RoleModerator, store.RoleAdmin}
+
+// AdminUsersHandler lists the users with their role, for admins
+func (h *Handler) AdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(UserKey).(ContextUser)
+
+	users, err := h.Users.List()
+	if err != nil {
+		log.Println("Error loading users:", err)
+		db.HandleError(w, http.StatusInternalServerError, "Error loading users")
+		return
+	}
+
+	db.RenderTemplate(w, "admin_users", map[string]interface{}{
+		"Title":    "Users",
+		"LoggedIn": userData.LoggedIn,
+		"Username": userData.Username,
+		"UserID":   userData.UserID,
+		"Users":    users,
+		"Roles":    roles,
+	})
+}
+
+// SetRoleHandler changes the role of a user, an admin cannot demote the last admin
+func (h *Handler) SetRoleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+
+	userID, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		db.HandleError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	role := r.FormValue("role")
+	if !store.ValidRole(role) {
+		db.HandleError(w, http.StatusBadRequest, "Invalid role")
+		return
+	}
+
+	user, err := h.Users.GetByID(userID)
+	if errors.Is(err, store.ErrNotFound) {
+		db.HandleError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if user.Role == store.RoleAdmin && role != store.RoleAdmin {
+		admins, err := h.Users.CountRole(store.RoleAdmin)
+		if err != nil {
+			db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if admins <= 1 {
+			db.HandleError(w, http.StatusBadRequest, "The forum needs at least one admin")
+			return
+		}
+	}
+
+	if err := h.Users.SetRole(userID, role); err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Failed to change the role")
+		return
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
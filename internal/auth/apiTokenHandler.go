@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	db "forum/internal/database"
+	"forum/internal/store"
+)
+
+const (
+	// apiTokenPrefix marks the personal access tokens so they are easy to spot in a leak
+	apiTokenPrefix = "forum_pat_"
+	maxAPITokens   = 20
+	maxTokenName   = 50
+)
+
+// tokenLifetimes are the days a new token can last for, 0 never expires
+var tokenLifetimes = []int{7, 30, 90, 365, 0}
+
+// Allows tells whether the request may use a scope. Sessions can do everything
+// their user can, tokens only what they were created for.
+func (u ContextUser) Allows(scope string) bool {
+	if u.TokenID == 0 {
+		return true
+	}
+	for _, s := range u.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenUser resolves the Authorization: Bearer header of an API request to its user,
+// returning an error status and message when the token is not valid, its user is
+// banned, or it lacks the scope of the method
+func (h *Handler) tokenUser(r *http.Request) (ContextUser, int, string) {
+	var userData ContextUser
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || !strings.HasPrefix(token, apiTokenPrefix) {
+		return userData, http.StatusUnauthorized, "Use an Authorization: Bearer header with a personal access token"
+	}
+	now := time.Now()
+	t, err := h.APITokens.Get(hashToken(token), now)
+	if errors.Is(err, store.ErrNotFound) {
+		return userData, http.StatusUnauthorized, "Invalid, expired or revoked token"
+	}
+	if err != nil {
+		log.Println("Error during token lookup:", err)
+		return userData, http.StatusInternalServerError, "Internal server error"
+	}
+
+	banned, err := h.loginBan(t.UserID)
+	if err != nil {
+		return userData, http.StatusInternalServerError, "Internal server error"
+	}
+	if banned != nil {
+		return userData, http.StatusForbidden, banMessage(banned)
+	}
+
+	userData = ContextUser{
+		LoggedIn:      true,
+		UserID:        t.UserID,
+		Username:      t.Username,
+		EmailVerified: t.EmailVerified,
+		Role:          t.Role,
+		TokenID:       t.ID,
+		Scopes:        t.Scopes,
+	}
+	scope := store.ScopeWrite
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		scope = store.ScopeRead
+	}
+	if !userData.Allows(scope) {
+		return userData, http.StatusForbidden, "This token does not have the " + scope + " scope"
+	}
+
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) >= refreshInterval {
+		if err := h.APITokens.Touch(t.ID, now); err != nil {
+			log.Printf("Failed to record the use of token %d: %v", t.ID, err)
+		}
+	}
+	return userData, 0, ""
+}
+
+// tokenScopes are the scopes the user can give their tokens
+func tokenScopes(userData ContextUser) []string {
+	scopes := []string{store.ScopeRead, store.ScopeWrite}
+	if userData.CanModerate() {
+		scopes = append(scopes, store.ScopeModerate)
+	}
+	return scopes
+}
+
+// renderAPITokens shows the tokens of the user with the form for a new one, data
+// holds what the form needs on top of them
+func (h *Handler) renderAPITokens(w http.ResponseWriter, userData ContextUser, data map[string]interface{}) {
+	tokens, err := h.APITokens.ListByUser(userData.UserID)
+	if err != nil {
+		log.Println("Error loading tokens:", err)
+		db.HandleError(w, http.StatusInternalServerError, "Error loading tokens")
+		return
+	}
+	data["Title"] = "Access tokens"
+	data["LoggedIn"] = userData.LoggedIn
+	data["Username"] = userData.Username
+	data["Tokens"] = tokens
+	data["Scopes"] = tokenScopes(userData)
+	data["Lifetimes"] = tokenLifetimes
+	data["Now"] = time.Now()
+	db.RenderTemplate(w, "api_tokens", data)
+}
+
+// APITokensHandler lists the personal access tokens of the user and creates new ones,
+// whose secret is shown only once
+func (h *Handler) APITokensHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(UserKey).(ContextUser)
+
+	if r.Method == http.MethodGet {
+		h.renderAPITokens(w, userData, map[string]interface{}{})
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		db.HandleError(w, http.StatusBadRequest, "Invalid form")
+		return
+	}
+	t := &store.APIToken{UserID: userData.UserID, Name: strings.TrimSpace(r.FormValue("name"))}
+	for _, scope := range tokenScopes(userData) {
+		for _, picked := range r.Form["scope"] {
+			if picked == scope {
+				t.Scopes = append(t.Scopes, scope)
+			}
+		}
+	}
+	// every request needs read or write, moderate only comes on top of them
+	requestScope := false
+	for _, scope := range t.Scopes {
+		if scope == store.ScopeRead || scope == store.ScopeWrite {
+			requestScope = true
+		}
+	}
+	days, err := strconv.Atoi(r.FormValue("days"))
+	validDays := false
+	for _, d := range tokenLifetimes {
+		if err == nil && d == days {
+			validDays = true
+		}
+	}
+
+	tokens, err := h.APITokens.ListByUser(userData.UserID)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	var formError string
+	switch {
+	case t.Name == "" || len(t.Name) > maxTokenName:
+		formError = "Give the token a name of at most 50 characters."
+	case len(t.Scopes) == 0 || len(t.Scopes) != len(r.Form["scope"]):
+		formError = "Pick at least one of the scopes offered."
+	case !requestScope:
+		formError = "Pick read or write as well, moderate alone cannot make any request."
+	case !validDays:
+		formError = "Pick how long the token lasts."
+	case len(tokens) >= maxAPITokens:
+		formError = "You have too many tokens, revoke one first."
+	}
+	if formError != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		h.renderAPITokens(w, userData, map[string]interface{}{"Error": formError, "Name": t.Name})
+		return
+	}
+
+	if days > 0 {
+		expiresAt := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+		t.ExpiresAt = &expiresAt
+	}
+	secret := randomToken()
+	if secret == "" {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	secret = apiTokenPrefix + secret
+	if err := h.APITokens.Create(t, hashToken(secret)); err != nil {
+		log.Println("Error creating token:", err)
+		db.HandleError(w, http.StatusInternalServerError, "Failed to create token")
+		return
+	}
+
+	h.renderAPITokens(w, userData, map[string]interface{}{"NewToken": secret, "NewName": t.Name})
+}
+
+// RevokeAPITokenHandler ends one of the personal access tokens of the user
+func (h *Handler) RevokeAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(UserKey).(ContextUser)
+
+	tokenID, err := strconv.Atoi(r.FormValue("token_id"))
+	if err != nil {
+		db.HandleError(w, http.StatusBadRequest, "Invalid token ID")
+		return
+	}
+	err = h.APITokens.Revoke(tokenID, userData.UserID, time.Now())
+	if errors.Is(err, store.ErrNotFound) {
+		db.HandleError(w, http.StatusNotFound, "Token not found")
+		return
+	}
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Failed to revoke token")
+		return
+	}
+	http.Redirect(w, r, "/account/tokens", http.StatusSeeOther)
+}
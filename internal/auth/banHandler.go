@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	db "forum/internal/database"
+	"forum/internal/store"
+)
+
+const (
+	maxBanReason      = 200
+	maxSuspensionDays = 365
+)
+
+// banKinds is the order the sanction picker lists them in
+var banKinds = []string{store.BanSuspension, store.BanPermanent, store.BanShadow}
+
+// loginBan returns the ban or suspension keeping the user from logging in, nil
+// if there is none; a shadowban does not
+func (h *Handler) loginBan(userID int) (*store.Ban, error) {
+	bans, err := h.Bans.Active(userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for _, ban := range bans {
+		if ban.Kind != store.BanShadow {
+			return &ban, nil
+		}
+	}
+	return nil, nil
+}
+
+// banMessage tells a user why they cannot log in and until when
+func banMessage(ban *store.Ban) string {
+	if ban.ExpiresAt != nil {
+		return "This account is suspended until " + ban.ExpiresAt.Format("Jan 02, 2006 15:04") + ": " + ban.Reason
+	}
+	return "This account is banned: " + ban.Reason
+}
+
+// canSanction tells whether the user can ban the target, whose role must be below theirs
+func canSanction(user ContextUser, target *store.User) bool {
+	return target.ID != user.UserID && !store.RoleAtLeast(target.Role, user.Role)
+}
+
+// sanctionedUser is a user as listed on the moderation users page
+type sanctionedUser struct {
+	store.User
+	Bans        []store.Ban
+	CanSanction bool
+}
+
+// ModerationUsersHandler lists the users with the bans in force on them and the forms to
+// ban them, for moderators
+func (h *Handler) ModerationUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(UserKey).(ContextUser)
+
+	users, err := h.Users.List()
+	if err != nil {
+		log.Println("Error loading users:", err)
+		db.HandleError(w, http.StatusInternalServerError, "Error loading users")
+		return
+	}
+	bans, err := h.Bans.ListActive(time.Now())
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Error loading bans")
+		return
+	}
+	byUser := make(map[int][]store.Ban)
+	for _, ban := range bans {
+		byUser[ban.UserID] = append(byUser[ban.UserID], ban)
+	}
+
+	views := make([]sanctionedUser, len(users))
+	for i := range users {
+		views[i] = sanctionedUser{User: users[i], Bans: byUser[users[i].ID], CanSanction: canSanction(userData, &users[i])}
+	}
+
+	db.RenderTemplate(w, "moderation_users", map[string]interface{}{
+		"Title":    "Users",
+		"LoggedIn": userData.LoggedIn,
+		"Username": userData.Username,
+		"Users":    views,
+		"Kinds":    banKinds,
+	})
+}
+
+// BanUserHandler suspends, bans or shadowbans a user. Suspended and banned users are
+// logged out everywhere.
+func (h *Handler) BanUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(UserKey).(ContextUser)
+
+	userID, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		db.HandleError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	ban := &store.Ban{
+		UserID:      userID,
+		ModeratorID: userData.UserID,
+		Kind:        r.FormValue("kind"),
+		Reason:      strings.TrimSpace(r.FormValue("reason")),
+	}
+	switch ban.Kind {
+	case store.BanSuspension:
+		days, err := strconv.Atoi(r.FormValue("days"))
+		if err != nil || days < 1 || days > maxSuspensionDays {
+			db.HandleError(w, http.StatusBadRequest, "Suspensions last from 1 to 365 days")
+			return
+		}
+		expiresAt := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+		ban.ExpiresAt = &expiresAt
+	case store.BanPermanent, store.BanShadow:
+	default:
+		db.HandleError(w, http.StatusBadRequest, "Invalid kind of ban")
+		return
+	}
+	if ban.Reason == "" || len(ban.Reason) > maxBanReason {
+		db.HandleError(w, http.StatusBadRequest, "Give a reason of at most 200 characters")
+		return
+	}
+
+	target, err := h.Users.GetByID(userID)
+	if errors.Is(err, store.ErrNotFound) {
+		db.HandleError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if !canSanction(userData, target) {
+		db.HandleError(w, http.StatusForbidden, "You can only ban users below your role")
+		return
+	}
+
+	if err := h.Bans.Create(ban, moderationEntry(userData, ban.Kind, userID, ban.Reason)); err != nil {
+		log.Printf("Failed to ban user %d: %v", userID, err)
+		db.HandleError(w, http.StatusInternalServerError, "Failed to ban user")
+		return
+	}
+	if ban.Kind != store.BanShadow {
+		if err := h.Sessions.DeleteByUser(userID); err != nil {
+			log.Printf("Failed to end the sessions of banned user %d: %v", userID, err)
+		}
+	}
+
+	http.Redirect(w, r, "/moderation/users", http.StatusSeeOther)
+}
+
+// LiftBanHandler ends a ban before it expires
+func (h *Handler) LiftBanHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(UserKey).(ContextUser)
+
+	banID, err := strconv.Atoi(r.FormValue("ban_id"))
+	if err != nil {
+		db.HandleError(w, http.StatusBadRequest, "Invalid ban ID")
+		return
+	}
+	now := time.Now()
+	bans, err := h.Bans.ListActive(now)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	var ban *store.Ban
+	for i := range bans {
+		if bans[i].ID == banID {
+			ban = &bans[i]
+		}
+	}
+	if ban == nil {
+		db.HandleError(w, http.StatusNotFound, "Ban not found")
+		return
+	}
+
+	target, err := h.Users.GetByID(ban.UserID)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if !canSanction(userData, target) {
+		db.HandleError(w, http.StatusForbidden, "You can only lift the bans of users below your role")
+		return
+	}
+
+	err = h.Bans.Lift(banID, now, moderationEntry(userData, "lift "+ban.Kind, ban.UserID, ban.Reason))
+	if errors.Is(err, store.ErrNotFound) {
+		db.HandleError(w, http.StatusNotFound, "Ban not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to lift ban %d: %v", banID, err)
+		db.HandleError(w, http.StatusInternalServerError, "Failed to lift ban")
+		return
+	}
+
+	http.Redirect(w, r, "/moderation/users", http.StatusSeeOther)
+}
+
+// moderationEntry is the moderation log entry of an action on a user
+func moderationEntry(userData ContextUser, action string, targetUserID int, note string) *store.ModerationEntry {
+	return &store.ModerationEntry{
+		ModeratorID:  userData.UserID,
+		Action:       action,
+		TargetUserID: targetUserID,
+		Note:         note,
+	}
+}
@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	db "forum/internal/database"
+	"forum/internal/store"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPassword = "correct horse"
+
+// newUser creates a user with the role and testPassword
+func newUser(t *testing.T, h *Handler, name, role string) int {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, err := h.Users.Create(name, name+"@example.com", string(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Users.SetRole(userID, role); err != nil {
+		t.Fatal(err)
+	}
+	return userID
+}
+
+// newSession logs the user in, returning the session id
+func newSession(t *testing.T, h *Handler, userID int) string {
+	t.Helper()
+	now := time.Now()
+	session := &store.Session{
+		ID:         GenerateSessionID(),
+		UserID:     userID,
+		CSRFToken:  GenerateCSRFToken(),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(time.Hour),
+	}
+	if err := h.Sessions.Create(session); err != nil {
+		t.Fatal(err)
+	}
+	return session.ID
+}
+
+// moderate posts a moderation form to the handler as the moderator
+func moderate(h http.HandlerFunc, moderator ContextUser, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/moderation", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(context.WithValue(req.Context(), UserKey, moderator))
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+// banForm is the form banning the user for the reason
+func banForm(userID int, kind string) url.Values {
+	form := url.Values{"user_id": {strconv.Itoa(userID)}, "kind": {kind}, "reason": {"spam"}}
+	if kind == store.BanSuspension {
+		form.Set("days", "7")
+	}
+	return form
+}
+
+// sessionUser runs a request with the session cookie through AuthMiddleware,
+// returning who it was made as
+func sessionUser(h *Handler, sessionID string) ContextUser {
+	var userData ContextUser
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userData = r.Context().Value(UserKey).(ContextUser)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: sessionID})
+	h.AuthMiddleware(next).ServeHTTP(httptest.NewRecorder(), req)
+	return userData
+}
+
+// login posts the login form of the user, answering 303 when it lets them in
+func login(h *Handler, name string) int {
+	form := url.Values{"email": {name + "@example.com"}, "password": {testPassword}}
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	h.LoginHandler(rec, req)
+	return rec.Code
+}
+
+func TestCanSanction(t *testing.T) {
+	admin := ContextUser{LoggedIn: true, UserID: 1, Role: store.RoleAdmin}
+	moderator := ContextUser{LoggedIn: true, UserID: 2, Role: store.RoleModerator}
+	tests := []struct {
+		name   string
+		user   ContextUser
+		target store.User
+		want   bool
+	}{
+		{"moderator on user", moderator, store.User{ID: 3, Role: store.RoleUser}, true},
+		{"moderator on moderator", moderator, store.User{ID: 4, Role: store.RoleModerator}, false},
+		{"moderator on admin", moderator, store.User{ID: 1, Role: store.RoleAdmin}, false},
+		{"admin on moderator", admin, store.User{ID: 2, Role: store.RoleModerator}, true},
+		{"admin on admin", admin, store.User{ID: 5, Role: store.RoleAdmin}, false},
+		{"moderator on themselves", moderator, store.User{ID: 2, Role: store.RoleModerator}, false},
+	}
+	for _, tt := range tests {
+		if got := canSanction(tt.user, &tt.target); got != tt.want {
+			t.Errorf("%s: canSanction = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBanNeedsLowerRole(t *testing.T) {
+	h, _ := newTestHandler(t)
+	modID := newUser(t, h, "mod_m", store.RoleModerator)
+	adminID := newUser(t, h, "admin_a", store.RoleAdmin)
+	moderator := ContextUser{LoggedIn: true, UserID: modID, Role: store.RoleModerator}
+
+	for _, targetID := range []int{adminID, modID} {
+		if rec := moderate(h.BanUserHandler, moderator, banForm(targetID, store.BanPermanent)); rec.Code != http.StatusForbidden {
+			t.Errorf("banning user %d: status %d, want %d", targetID, rec.Code, http.StatusForbidden)
+		}
+		if bans, err := h.Bans.Active(targetID, time.Now()); err != nil || len(bans) != 0 {
+			t.Errorf("user %d has bans %v %v, want none", targetID, bans, err)
+		}
+	}
+}
+
+func TestSuspensionLogsOut(t *testing.T) {
+	h, stores := newTestHandler(t)
+	modID := newUser(t, h, "mod_m", store.RoleModerator)
+	userID := newUser(t, h, "user_u", store.RoleUser)
+	sessionID := newSession(t, h, userID)
+	moderator := ContextUser{LoggedIn: true, UserID: modID, Role: store.RoleModerator}
+
+	if rec := moderate(h.BanUserHandler, moderator, banForm(userID, store.BanSuspension)); rec.Code != http.StatusSeeOther {
+		t.Fatalf("suspending: status %d, body %s", rec.Code, rec.Body)
+	}
+	if got := sessionUser(h, sessionID); got.LoggedIn {
+		t.Error("the session of the suspended user still works")
+	}
+	if sessions, err := h.Sessions.ListByUser(userID); err != nil || len(sessions) != 0 {
+		t.Errorf("suspended user has sessions %v %v, want none", sessions, err)
+	}
+	if code := login(h, "user_u"); code != http.StatusForbidden {
+		t.Errorf("login while suspended: status %d, want %d", code, http.StatusForbidden)
+	}
+
+	entries, err := stores.Moderation.ListLog(10)
+	if err != nil || len(entries) != 1 || entries[0].Action != store.BanSuspension || entries[0].TargetUserID != userID {
+		t.Errorf("moderation log %+v %v, want the suspension", entries, err)
+	}
+}
+
+func TestBannedSessionEnds(t *testing.T) {
+	h, _ := newTestHandler(t)
+	modID := newUser(t, h, "mod_m", store.RoleModerator)
+	userID := newUser(t, h, "user_u", store.RoleUser)
+	sessionID := newSession(t, h, userID)
+
+	// a session the ban did not end, like one started while it was written
+	ban := &store.Ban{UserID: userID, ModeratorID: modID, Kind: store.BanPermanent, Reason: "spam"}
+	entry := &store.ModerationEntry{ModeratorID: modID, Action: ban.Kind, TargetUserID: userID}
+	if err := h.Bans.Create(ban, entry); err != nil {
+		t.Fatal(err)
+	}
+	if got := sessionUser(h, sessionID); got.LoggedIn {
+		t.Error("the session of the banned user still works")
+	}
+	if sessions, err := h.Sessions.ListByUser(userID); err != nil || len(sessions) != 0 {
+		t.Errorf("banned user has sessions %v %v, want none", sessions, err)
+	}
+}
+
+func TestExpiredSuspension(t *testing.T) {
+	h, _ := newTestHandler(t)
+	modID := newUser(t, h, "mod_m", store.RoleModerator)
+	userID := newUser(t, h, "user_u", store.RoleUser)
+
+	expired := time.Now().Add(-time.Minute)
+	ban := &store.Ban{UserID: userID, ModeratorID: modID, Kind: store.BanSuspension, Reason: "spam", ExpiresAt: &expired}
+	entry := &store.ModerationEntry{ModeratorID: modID, Action: ban.Kind, TargetUserID: userID}
+	if err := h.Bans.Create(ban, entry); err != nil {
+		t.Fatal(err)
+	}
+	if code := login(h, "user_u"); code != http.StatusSeeOther {
+		t.Errorf("login after the suspension: status %d, want %d", code, http.StatusSeeOther)
+	}
+	if got := sessionUser(h, newSession(t, h, userID)); !got.LoggedIn {
+		t.Error("the session of a user whose suspension ended does not work")
+	}
+}
+
+func TestLiftBan(t *testing.T) {
+	h, stores := newTestHandler(t)
+	modID := newUser(t, h, "mod_m", store.RoleModerator)
+	userID := newUser(t, h, "user_u", store.RoleUser)
+	moderator := ContextUser{LoggedIn: true, UserID: modID, Role: store.RoleModerator}
+
+	if rec := moderate(h.BanUserHandler, moderator, banForm(userID, store.BanPermanent)); rec.Code != http.StatusSeeOther {
+		t.Fatalf("banning: status %d, body %s", rec.Code, rec.Body)
+	}
+	bans, err := h.Bans.Active(userID, time.Now())
+	if err != nil || len(bans) != 1 {
+		t.Fatalf("bans %v %v, want one", bans, err)
+	}
+	form := url.Values{"ban_id": {strconv.Itoa(bans[0].ID)}}
+	if rec := moderate(h.LiftBanHandler, moderator, form); rec.Code != http.StatusSeeOther {
+		t.Fatalf("lifting: status %d, body %s", rec.Code, rec.Body)
+	}
+	if code := login(h, "user_u"); code != http.StatusSeeOther {
+		t.Errorf("login after the ban was lifted: status %d, want %d", code, http.StatusSeeOther)
+	}
+	if entries, err := stores.Moderation.ListLog(1); err != nil || len(entries) != 1 || entries[0].Action != "lift "+store.BanPermanent {
+		t.Errorf("moderation log %+v %v, want the lift", entries, err)
+	}
+}
+
+func TestShadowban(t *testing.T) {
+	h, stores := newTestHandler(t)
+	modID := newUser(t, h, "mod_m", store.RoleModerator)
+	userID := newUser(t, h, "user_u", store.RoleUser)
+	otherID := newUser(t, h, "other_o", store.RoleUser)
+	sessionID := newSession(t, h, userID)
+	postID, err := stores.Posts.Create(userID, "Buy now", "Cheap watches", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moderator := ContextUser{LoggedIn: true, UserID: modID, Role: store.RoleModerator}
+
+	if rec := moderate(h.BanUserHandler, moderator, banForm(userID, store.BanShadow)); rec.Code != http.StatusSeeOther {
+		t.Fatalf("shadowbanning: status %d, body %s", rec.Code, rec.Body)
+	}
+	// they do not notice
+	if got := sessionUser(h, sessionID); !got.LoggedIn || got.UserID != userID {
+		t.Error("the session of the shadowbanned user was ended")
+	}
+	if code := login(h, "user_u"); code != http.StatusSeeOther {
+		t.Errorf("login while shadowbanned: status %d, want %d", code, http.StatusSeeOther)
+	}
+
+	feed := func(viewerID int) bool {
+		posts, err := stores.Posts.List(store.PostFilter{ViewerID: viewerID, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range posts {
+			if p.ID == postID {
+				return true
+			}
+		}
+		return false
+	}
+	if !feed(userID) {
+		t.Error("the shadowbanned user does not see their post")
+	}
+	if feed(otherID) || feed(0) {
+		t.Error("others see the post of the shadowbanned user")
+	}
+	if post, err := stores.Posts.Get(postID); err != nil || !post.Shadowbanned {
+		t.Errorf("post %+v %v, want it marked shadowbanned", post, err)
+	}
+}
+
+func TestBanIsNotWrittenWithoutLogEntry(t *testing.T) {
+	h, _ := newTestHandler(t)
+	modID := newUser(t, h, "mod_m", store.RoleModerator)
+	userID := newUser(t, h, "user_u", store.RoleUser)
+	sessionID := newSession(t, h, userID)
+	moderator := ContextUser{LoggedIn: true, UserID: modID, Role: store.RoleModerator}
+
+	// the log entry cannot be written
+	if _, err := db.DB.Exec("ALTER TABLE moderation_log RENAME TO moderation_log_gone"); err != nil {
+		t.Fatal(err)
+	}
+	if rec := moderate(h.BanUserHandler, moderator, banForm(userID, store.BanPermanent)); rec.Code != http.StatusInternalServerError {
+		t.Errorf("banning: status %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if bans, err := h.Bans.Active(userID, time.Now()); err != nil || len(bans) != 0 {
+		t.Errorf("bans %v %v, want none", bans, err)
+	}
+	if got := sessionUser(h, sessionID); !got.LoggedIn {
+		t.Error("the failed ban ended the sessions of the user")
+	}
+}
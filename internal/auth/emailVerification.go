@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	db "forum/internal/database"
+	forumMail "forum/internal/mail"
+	"forum/internal/store"
+)
+
+var (
+	verifyTTL       = flag.Duration("verify-ttl", 48*time.Hour, "How long an email verification link stays valid")
+	requireVerified = flag.Bool("require-verified-email", true, "Unverified users can log in but not post or comment")
+)
+
+// maxEmailLength is the longest address SMTP can deliver to
+const maxEmailLength = 254
+
+// validEmail accepts a bare address with a dotted domain, like name@example.com
+func validEmail(email string) bool {
+	if len(email) > maxEmailLength {
+		return false
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return false
+	}
+	at := strings.LastIndex(email, "@")
+	domain := email[at+1:]
+	return at > 0 && strings.Contains(domain, ".") && !strings.HasPrefix(domain, ".") && !strings.HasSuffix(domain, ".")
+}
+
+// sendVerification mails a new verification link to the user
+func (h *Handler) sendVerification(user *store.User) error {
+	token := randomToken()
+	if token == "" {
+		return errors.New("no randomness for the verification token")
+	}
+	if err := h.Verifications.Create(hashToken(token), user.ID, time.Now().Add(*verifyTTL)); err != nil {
+		return err
+	}
+
+	link := strings.TrimRight(*baseURL, "/") + "/verify-email?token=" + url.QueryEscape(token)
+	return h.Mailer.Send(forumMail.Message{
+		To:      user.Email,
+		Subject: "Verify your forum email address",
+		Body: fmt.Sprintf("Hello %s,\n\nWelcome to the forum! Open this link within %d hours "+
+			"to verify your email address:\n\n%s\n\n"+
+			"If you did not create an account, you can ignore this mail.",
+			user.Username, int(verifyTTL.Hours()), link),
+	})
+}
+
+// VerifyEmailHandler marks the address of a mailed link as verified
+func (h *Handler) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData, _ := r.Context().Value(UserKey).(ContextUser)
+
+	userID, err := h.Verifications.Consume(hashToken(r.URL.Query().Get("token")), time.Now())
+	if errors.Is(err, store.ErrNotFound) {
+		w.WriteHeader(http.StatusBadRequest)
+		db.RenderTemplate(w, "verify_email", map[string]interface{}{
+			"Title":    "Verify Email",
+			"LoggedIn": userData.LoggedIn,
+			"Username": userData.Username,
+			"Invalid":  true,
+		})
+		return
+	}
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if err := h.Users.MarkEmailVerified(userID, time.Now()); err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Failed to verify email")
+		return
+	}
+	// the other links of the account are no use anymore
+	if err := h.Verifications.DeleteByUser(userID); err != nil {
+		log.Printf("Failed to delete verification tokens: %v", err)
+	}
+
+	db.RenderTemplate(w, "verify_email", map[string]interface{}{
+		"Title":    "Verify Email",
+		"LoggedIn": userData.LoggedIn,
+		"Username": userData.Username,
+	})
+}
+
+// ResendVerificationHandler mails a new verification link to the current user
+func (h *Handler) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(UserKey).(ContextUser)
+	if userData.EmailVerified {
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
+
+	// throttled per account so the button cannot flood a mailbox
+	key := verifyKey(userData.UserID)
+	wait, err := h.Limiter.Wait(key)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if wait > 0 {
+		retryAfter(w, wait)
+		db.RenderTemplate(w, "error", map[string]interface{}{
+			"Code":    http.StatusTooManyRequests,
+			"Message": lockoutMessage(wait),
+		})
+		return
+	}
+	if err := h.Limiter.Hit(key); err != nil {
+		log.Printf("Failed to throttle verification mail: %v", err)
+	}
+
+	user, err := h.Users.GetByID(userData.UserID)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	// only the newest link works, like a password reset
+	if err := h.Verifications.DeleteByUser(user.ID); err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if err := h.sendVerification(user); err != nil {
+		log.Printf("Failed to send verification to user %d: %v", user.ID, err)
+		db.HandleError(w, http.StatusInternalServerError, "Failed to send the verification mail")
+		return
+	}
+	http.Redirect(w, r, "/account?verification=sent", http.StatusSeeOther)
+}
+
+// CanPost tells whether the user may write posts and comments, which takes a
+// verified email unless -require-verified-email is off
+func (u ContextUser) CanPost() bool {
+	return u.LoggedIn && (u.EmailVerified || !*requireVerified)
+}
+
+// RequireVerified keeps users whose email is not verified from posting and
+// commenting, unless the policy is turned off. It runs behind RequireAuth.
+func RequireVerified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userData, _ := r.Context().Value(UserKey).(ContextUser)
+		if !userData.CanPost() {
+			db.HandleError(w, http.StatusForbidden,
+				"Please verify your email address first. You can resend the link from your account page.")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
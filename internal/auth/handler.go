@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"forum/internal/mail"
+	"forum/internal/store"
+)
+
+// Handler serves login with a password or a provider and its optional second
+// factor, registration, sessions, password resets and email verification on top
+// of the stores
+type Handler struct {
+	Users         store.UserStore
+	Sessions      store.SessionStore
+	Resets        store.PasswordResetStore
+	Verifications store.EmailVerificationStore
+	TwoFactor     store.TwoFactorStore
+	PendingLogins store.PendingLoginStore
+	Identities    store.IdentityStore
+	Moderation    store.ModerationStore
+	Bans          store.BanStore
+	APITokens     store.APITokenStore
+	Providers     []Provider
+	Limiter       *Limiter
+	Mailer        mail.Mailer
+}
+
+func New(s *store.Stores, mailer mail.Mailer, providers []Provider) *Handler {
+	return &Handler{
+		Users:         s.Users,
+		Sessions:      s.Sessions,
+		Resets:        s.Resets,
+		Verifications: s.Verifications,
+		TwoFactor:     s.TwoFactor,
+		PendingLogins: s.PendingLogins,
+		Identities:    s.Identities,
+		Moderation:    s.Moderation,
+		Bans:          s.Bans,
+		APITokens:     s.APITokens,
+		Providers:     providers,
+		Limiter:       &Limiter{Throttles: s.Throttles},
+		Mailer:        mailer,
+	}
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"forum/internal/store"
+)
+
+var (
+	maxAttempts   = flag.Int("auth-max-attempts", 5, "Failed logins, or registrations, before a temporary lockout")
+	lockoutPeriod = flag.Duration("auth-lockout", 15*time.Minute, "Lockout after too many attempts, older attempts are forgotten")
+)
+
+// baseBackoff is the wait after the first counted attempt, doubled on each next one
+const baseBackoff = time.Second
+
+// Limiter throttles logins and registrations per client IP and per account.
+// Every counted attempt backs the key off exponentially until maxAttempts locks
+// it out for lockoutPeriod; the counters live in the database so a restart
+// does not clear them.
+type Limiter struct {
+	Throttles store.ThrottleStore
+}
+
+// Wait returns how long the most throttled of keys must still wait
+func (l *Limiter) Wait(keys ...string) (time.Duration, error) {
+	now := time.Now().UTC()
+	var wait time.Duration
+	for _, key := range keys {
+		t, err := l.Throttles.Get(key)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if t.LockedUntil != nil && t.LockedUntil.Sub(now) > wait {
+			wait = t.LockedUntil.Sub(now)
+		}
+	}
+	return wait, nil
+}
+
+// Hit counts an attempt against each key and backs it off
+func (l *Limiter) Hit(keys ...string) error {
+	now := time.Now().UTC()
+	for _, key := range keys {
+		attempts, err := l.Throttles.AddAttempt(key, now, *lockoutPeriod)
+		if err != nil {
+			return err
+		}
+		if attempts >= *maxAttempts {
+			log.Printf("Locking out %s for %v after %d attempts", key, *lockoutPeriod, attempts)
+		}
+		if err := l.Throttles.SetLockedUntil(key, now.Add(backoff(attempts))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset forgets the attempts of key, after a successful login
+func (l *Limiter) Reset(key string) error {
+	return l.Throttles.Reset(key)
+}
+
+// Audit records a failed or refused attempt
+func (l *Limiter) Audit(kind string, r *http.Request, email, reason string) {
+	attempt := &store.AuthAttempt{Kind: kind, IP: clientIP(r), Email: email, Reason: reason}
+	if err := l.Throttles.LogAttempt(attempt); err != nil {
+		log.Printf("Failed to audit %s attempt: %v", kind, err)
+	}
+}
+
+// Cleanup forgets the keys without attempts in the last lockout period
+func (l *Limiter) Cleanup() error {
+	now := time.Now().UTC()
+	return l.Throttles.DeleteExpired(now.Add(-*lockoutPeriod), now)
+}
+
+func backoff(attempts int) time.Duration {
+	if attempts >= *maxAttempts || attempts > 30 {
+		return *lockoutPeriod
+	}
+	wait := baseBackoff << (attempts - 1)
+	if wait > *lockoutPeriod {
+		return *lockoutPeriod
+	}
+	return wait
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func accountKey(email string) string {
+	return "account:" + email
+}
+
+func registerKey(ip string) string {
+	return "register:" + ip
+}
+
+func resetKey(ip string) string {
+	return "reset:" + ip
+}
+
+func verifyKey(userID int) string {
+	return "verify:" + strconv.Itoa(userID)
+}
+
+func twoFactorKey(userID int) string {
+	return "2fa:" + strconv.Itoa(userID)
+}
+
+// clientIP is the address of the peer, without its port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// lockoutMessage tells the user how long to wait, rounded up
+func lockoutMessage(wait time.Duration) string {
+	if wait >= time.Minute {
+		minutes := int((wait + time.Minute - 1) / time.Minute)
+		return fmt.Sprintf("Too many attempts. Try again in %d minute(s).", minutes)
+	}
+	seconds := int((wait + time.Second - 1) / time.Second)
+	return fmt.Sprintf("Too many attempts. Try again in %d second(s).", seconds)
+}
+
+// retryAfter sets the Retry-After header of a 429 response
+func retryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprint(int((wait+time.Second-1)/time.Second)))
+	w.WriteHeader(http.StatusTooManyRequests)
+}
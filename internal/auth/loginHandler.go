@@ -1,22 +1,26 @@
 package auth
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"strings"
 	"time"
 
 	db "forum/internal/database"
+	"forum/internal/store"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 // User login
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	var cred Credentials
 	if r.Method == http.MethodGet {
-		db.RenderTemplate(w, "login", map[string]interface{}{
-			"Title":       "Login Page",
-			"Credentials": cred,
+		h.renderLogin(w, map[string]interface{}{
+			"Title":         "Login Page",
+			"Credentials":   cred,
+			"PasswordReset": r.URL.Query().Get("reset") == "1",
 		})
 		return
 	}
@@ -27,8 +31,8 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		cred.Password = strings.TrimSpace(r.FormValue("password"))
 
 		valid := true
-		if len(cred.Email) < 5 || len(cred.Email) > 30 {
-			cred.Error.Email = "Email must be between 5 and 100 characters"
+		if len(cred.Email) < 5 || len(cred.Email) > maxEmailLength {
+			cred.Error.Email = "Email must be between 5 and 254 characters"
 			valid = false
 		}
 		if len(cred.Password) < 5 || len(cred.Password) > 30 {
@@ -39,75 +43,152 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		// If validation fails, show errors
 		if !valid {
 			w.WriteHeader(http.StatusBadRequest)
-			db.RenderTemplate(w, "login", map[string]interface{}{
+			h.renderLogin(w, map[string]interface{}{
 				"Title":       "Login",
 				"Credentials": cred,
 			})
 			return
 		}
 
-		prep, err := db.DB.Prepare("SELECT id, email, username, password FROM users WHERE email = ?")
+		// throttle by client IP and by the targeted account
+		keys := []string{ipKey(clientIP(r)), accountKey(cred.Email)}
+		wait, err := h.Limiter.Wait(keys...)
 		if err != nil {
-			db.HandleError(w, http.StatusInternalServerError, "Prep error")
+			db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if wait > 0 {
+			h.Limiter.Audit("login", r, cred.Email, "locked out")
+			retryAfter(w, wait)
+			cred.Error.Form = lockoutMessage(wait)
+			h.renderLogin(w, map[string]interface{}{
+				"Title":       "Login",
+				"Credentials": cred,
+			})
 			return
 		}
-		defer prep.Close()
 
-		user := users{}
-		if err = prep.QueryRow(cred.Email).Scan(&user.userID, &user.dbEmail, &user.username, &user.storedHash); err != nil {
+		user, err := h.Users.GetByEmail(cred.Email)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err != nil {
+			h.loginFailed(r, cred.Email, keys, "unknown email")
 			w.WriteHeader(http.StatusBadRequest)
 			// timing attack prevention (always returning an error on failed login)
 			_ = bcrypt.CompareHashAndPassword([]byte("$2a$10$dummy"), []byte(cred.Password))
 			cred.Error.Password = "Invalid Password"
 			cred.Error.Email = "Invalid email"
-			db.RenderTemplate(w, "login", map[string]interface{}{
+			h.renderLogin(w, map[string]interface{}{
 				"Title":       "Login",
 				"Credentials": cred,
 			})
 			return
 		}
 
-		if err = bcrypt.CompareHashAndPassword([]byte(user.storedHash), []byte(cred.Password)); err != nil {
+		if err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(cred.Password)); err != nil {
+			h.loginFailed(r, cred.Email, keys, "wrong password")
 			w.WriteHeader(http.StatusBadRequest)
 			cred.Error.Password = "Invalid Password"
-			db.RenderTemplate(w, "login", map[string]interface{}{
+			h.renderLogin(w, map[string]interface{}{
 				"Title":       "Login",
 				"Credentials": cred,
 			})
 			return
 		}
 
-		// avoid multiple active sessions for the same user
-		_, err = db.DB.Exec("DELETE FROM sessions WHERE user_id = ?", user.userID)
-		if err != nil {
-			db.HandleError(w, http.StatusInternalServerError, "Failed to delete existing session")
-			return
+		if err = h.Limiter.Reset(accountKey(cred.Email)); err != nil {
+			log.Printf("Failed to reset login throttle: %v", err)
 		}
 
-		genSessionID := GenerateSessionID()
-		expiresAT := time.Now().Add(24 * time.Hour)
-		if genSessionID == "" {
-			db.HandleError(w, http.StatusInternalServerError, "Internal server error")
-			return
-		}
+		h.finishLogin(w, r, user.ID, r.FormValue("remember") == "1")
+	} else {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+}
 
-		prep, err = db.DB.Prepare("INSERT INTO sessions (id , user_id, expires_at) VALUES (?,?,?)")
-		if err != nil {
-			db.HandleError(w, http.StatusInternalServerError, "Internal server error")
-			return
-		}
-		defer prep.Close()
+// loginFailed counts a failed login against the throttled keys and audits it
+func (h *Handler) loginFailed(r *http.Request, email string, keys []string, reason string) {
+	if err := h.Limiter.Hit(keys...); err != nil {
+		log.Printf("Failed to throttle login: %v", err)
+	}
+	h.Limiter.Audit("login", r, email, reason)
+}
 
-		if _, err = prep.Exec(genSessionID, user.userID, expiresAT); err != nil {
+// finishLogin logs in a user who proved who they are, through the second
+// factor step first if they turned it on, unless they are banned
+func (h *Handler) finishLogin(w http.ResponseWriter, r *http.Request, userID int, remember bool) {
+	ban, err := h.loginBan(userID)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if ban != nil {
+		var cred Credentials
+		cred.Error.Form = banMessage(ban)
+		w.WriteHeader(http.StatusForbidden)
+		h.renderLogin(w, map[string]interface{}{
+			"Title":       "Login",
+			"Credentials": cred,
+		})
+		return
+	}
+
+	totp, err := h.TwoFactor.Get(userID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if err == nil && totp.EnabledAt != nil {
+		if err = h.startPendingLogin(w, userID, remember); err != nil {
 			db.HandleError(w, http.StatusInternalServerError, "Internal server error")
 			return
 		}
+		http.Redirect(w, r, "/login/two-factor", http.StatusSeeOther)
+		return
+	}
 
-		setSessionCookie(w, genSessionID, expiresAT)
-
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-	} else {
-		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+	if err = h.startSession(w, r, userID, remember); err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// startSession logs the user in on this device, on top of the sessions they
+// have on other devices
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, userID int, remember bool) error {
+	genSessionID := GenerateSessionID()
+	csrfToken := GenerateCSRFToken()
+	now := time.Now()
+	expiresAT := now.Add(sessionLifetime(remember))
+	if genSessionID == "" || csrfToken == "" {
+		return errors.New("no randomness for the session")
+	}
+
+	session := &store.Session{
+		ID:         genSessionID,
+		UserID:     userID,
+		CSRFToken:  csrfToken,
+		UserAgent:  r.UserAgent(),
+		IP:         clientIP(r),
+		Remember:   remember,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  expiresAT,
+	}
+	if err := h.Sessions.Create(session); err != nil {
+		return err
+	}
+
+	setSessionCookie(w, genSessionID, expiresAT, remember)
+	return nil
+}
+
+// renderLogin renders the login page with the buttons of the login providers
+func (h *Handler) renderLogin(w http.ResponseWriter, data map[string]interface{}) {
+	data["Providers"] = h.Providers
+	db.RenderTemplate(w, "login", data)
 }
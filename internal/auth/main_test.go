@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	db "forum/internal/database"
+	"forum/internal/store"
+)
+
+// TestMain runs the tests from the root of the repository, where the templates are
+func TestMain(m *testing.M) {
+	if err := os.Chdir(filepath.Join("..", "..")); err != nil {
+		log.Fatal(err)
+	}
+	log.SetOutput(io.Discard)
+	// the tests also run without the sqlite_fts5 build tag
+	if err := flag.Set("search-fallback", "true"); err != nil {
+		log.Fatal(err)
+	}
+	if err := db.InitTemplates(); err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(m.Run())
+}
+
+// newTestHandler returns a handler on a fresh migrated database with the providers
+func newTestHandler(t *testing.T, providers ...Provider) (*Handler, *store.Stores) {
+	t.Helper()
+	if err := db.InitDatabase(filepath.Join(t.TempDir(), "forum.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DB.Close() })
+	stores := store.NewSQLite(db.DB, db.SearchEnabled)
+	return New(stores, nil, providers), stores
+}
@@ -2,15 +2,37 @@ package auth
 
 import (
 	"context"
-	"database/sql"
+	"crypto/subtle"
+	"errors"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	db "forum/internal/database"
+	"forum/internal/store"
 )
 
-func AuthMiddleware(next http.Handler) http.Handler {
+// refreshInterval is how stale last_seen_at and expires_at may get, so a session
+// is not written on every request
+const refreshInterval = time.Minute
+
+// refresh records that the session is in use and slides its expiry, reissuing
+// the cookie with the new one
+func (h *Handler) refresh(w http.ResponseWriter, session *store.Session) {
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) < refreshInterval {
+		return
+	}
+	expiresAt := now.Add(sessionLifetime(session.Remember))
+	if err := h.Sessions.Touch(session.ID, now, expiresAt); err != nil {
+		log.Printf("Failed to refresh session: %v", err)
+		return
+	}
+	setSessionCookie(w, session.ID, expiresAt, session.Remember)
+}
+
+func (h *Handler) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Default context with no user
 		ctx := r.Context()
@@ -20,54 +42,61 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			Username: "",
 		}
 
+		// API clients authenticate with a personal access token instead of the cookie
+		if strings.HasPrefix(r.URL.Path, db.APIPrefix) && r.Header.Get("Authorization") != "" {
+			userData, status, msg := h.tokenUser(r)
+			if status != 0 {
+				if status == http.StatusUnauthorized {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="forum"`)
+				}
+				db.HandleJSONError(w, status, msg)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, UserKey, userData)))
+			return
+		}
+
 		// Check for session cookie
 		cookie, err := r.Cookie(SessionCookieName)
 		if err == nil {
-			prep, err := db.DB.Prepare(`
-                SELECT s.user_id, s.expires_at, u.username 
-                FROM sessions s
-                JOIN users u ON s.user_id = u.id 
-                WHERE s.id = ? AND s.expires_at > ?
-            `)
+			session, err := h.Sessions.Get(cookie.Value)
 			if err != nil {
-				log.Println("DB prepare error:", err)
+				if errors.Is(err, store.ErrNotFound) {
+					log.Println("No session found for the provided cookie.")
+				} else {
+					log.Println("Error during session lookup:", err)
+					db.HandleRequestError(w, r, http.StatusInternalServerError, "Internal server error")
+					return
+				}
 			}
+			// a banned or suspended user loses every session they have
 			if err == nil {
-				defer prep.Close()
-				var session Sessions
-				err = prep.QueryRow(cookie.Value, time.Now()).Scan(
-					&session.UserID,
-					&session.ExpiresAt,
-					&session.Username,
-				)
-				if err != nil {
-					if err == sql.ErrNoRows {
-						log.Println("No session found for the provided cookie.")
-					} else {
-						log.Println("Error during session lookup:", err)
-						db.HandleError(w, http.StatusInternalServerError, "Internal server error")
-						return
-					}
+				banned, banErr := h.loginBan(session.UserID)
+				if banErr != nil {
+					db.HandleRequestError(w, r, http.StatusInternalServerError, "Internal server error")
+					return
 				}
-				if err == nil {
-					userData.LoggedIn = true
-					userData.UserID = session.UserID
-					userData.Username = session.Username
-					log.Printf("Session set to user: %v", session.Username)
-				} else {
-					log.Println("Invalid or expired session - remove cookie")
-					http.SetCookie(w, &http.Cookie{
-						Name:     SessionCookieName,
-						Value:    "",
-						Path:     "/",
-						Expires:  time.Now().Add(-1 * time.Hour),
-						MaxAge:   -1,
-						HttpOnly: true,
-						Secure:   *secureCookie,
-						SameSite: http.SameSiteLaxMode,
-					})
+				if banned != nil {
+					if err := h.Sessions.DeleteByUser(session.UserID); err != nil {
+						log.Printf("Failed to end the sessions of banned user %d: %v", session.UserID, err)
+					}
+					err = store.ErrNotFound
 				}
 			}
+			if err == nil {
+				userData.LoggedIn = true
+				userData.UserID = session.UserID
+				userData.Username = session.Username
+				userData.EmailVerified = session.EmailVerified
+				userData.Role = session.Role
+				userData.SessionID = session.ID
+				userData.CSRFToken = session.CSRFToken
+				h.refresh(w, session)
+				log.Printf("Session set to user: %v", session.Username)
+			} else {
+				log.Println("Invalid or expired session - remove cookie")
+				expireSessionCookie(w)
+			}
 		}
 
 		// Add user data to context
@@ -76,6 +105,36 @@ func AuthMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// CSRFMiddleware rejects the state-changing requests of a session that do not carry
+// its CSRF token, in the csrf_token form field or the X-CSRF-Token header, and hands
+// the token to the templates. It runs behind AuthMiddleware. Requests with a token
+// need none, browsers do not send it on their own.
+func CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userData, _ := r.Context().Value(UserKey).(ContextUser)
+		if !userData.LoggedIn || userData.TokenID != 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w = db.WithCSRFToken(w, userData.CSRFToken)
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			token := r.Header.Get("X-CSRF-Token")
+			if token == "" {
+				token = r.FormValue("csrf_token")
+			}
+			if userData.CSRFToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(userData.CSRFToken)) != 1 {
+				log.Printf("CSRF check failed for %s %s", r.Method, r.URL.Path)
+				db.HandleRequestError(w, r, http.StatusForbidden, "Invalid or missing CSRF token")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func RequireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		userData, ok := r.Context().Value(UserKey).(ContextUser)
@@ -86,3 +145,20 @@ func RequireAuth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequireRole lets through the users with at least that role, like RequireAuth
+// it sends anonymous users to the login page
+func RequireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userData, ok := r.Context().Value(UserKey).(ContextUser)
+		if !ok || !userData.LoggedIn {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		if !userData.HasRole(role) {
+			db.HandleError(w, http.StatusForbidden, "You are not allowed to see this page")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
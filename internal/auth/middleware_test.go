@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"forum/internal/store"
+)
+
+// staleSession stores a session last seen long enough ago to be refreshed
+func staleSession(t *testing.T, h *Handler, remember bool) *store.Session {
+	t.Helper()
+	userID := newUser(t, h, "ada_l", store.RoleUser)
+	seen := time.Now().Add(-2 * refreshInterval)
+	session := &store.Session{
+		ID:         GenerateSessionID(),
+		UserID:     userID,
+		CSRFToken:  GenerateCSRFToken(),
+		Remember:   remember,
+		CreatedAt:  seen,
+		LastSeenAt: seen,
+		ExpiresAt:  seen.Add(sessionLifetime(remember)),
+	}
+	if err := h.Sessions.Create(session); err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+// refreshedCookie refreshes the session, returning the cookie sent back
+func refreshedCookie(t *testing.T, h *Handler, session *store.Session) *http.Cookie {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.refresh(rec, session)
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != SessionCookieName {
+		t.Fatalf("refresh set cookies %v, want the session cookie", cookies)
+	}
+	return cookies[0]
+}
+
+func TestRefreshKeepsSessionCookie(t *testing.T) {
+	h, _ := newTestHandler(t)
+	session := staleSession(t, h, false)
+
+	if cookie := refreshedCookie(t, h, session); !cookie.Expires.IsZero() || cookie.MaxAge != 0 {
+		t.Errorf("cookie of a session without remember me expires at %v, want it to end with the browser", cookie.Expires)
+	}
+	// the server still moves the expiry along
+	refreshed, err := h.Sessions.Get(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !refreshed.ExpiresAt.After(session.ExpiresAt) {
+		t.Errorf("expiry %v not moved past %v", refreshed.ExpiresAt, session.ExpiresAt)
+	}
+}
+
+func TestRefreshExtendsRememberedCookie(t *testing.T) {
+	h, _ := newTestHandler(t)
+	session := staleSession(t, h, true)
+
+	cookie := refreshedCookie(t, h, session)
+	if want := time.Now().Add(sessionLifetime(true)); cookie.Expires.Before(want.Add(-time.Minute)) {
+		t.Errorf("remembered cookie expires at %v, want about %v", cookie.Expires, want)
+	}
+}
@@ -0,0 +1,301 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	db "forum/internal/database"
+	"forum/internal/store"
+)
+
+// Provider is an OAuth2 provider users can log in with
+type Provider interface {
+	// Name identifies the provider in URLs and in the user_identities table
+	Name() string
+	// Title is shown on the buttons
+	Title() string
+	// AuthCodeURL is where the browser logs in at the provider
+	AuthCodeURL(ctx context.Context, state, nonce, challenge, redirectURI string) (string, error)
+	// Exchange trades the code the provider sent back for the profile of the user
+	Exchange(ctx context.Context, code, verifier, nonce, redirectURI string) (*Profile, error)
+}
+
+// Profile is the user as the provider knows them
+type Profile struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+}
+
+const (
+	// stateCookieName carries the state, the PKCE verifier and the nonce of a
+	// login between the redirect to the provider and the callback
+	stateCookieName = "oauth_state"
+	stateTTL        = 10 * time.Minute
+)
+
+// provider returns the configured provider with that name, nil if there is none
+func (h *Handler) provider(name string) Provider {
+	for _, p := range h.Providers {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func redirectURI(p Provider) string {
+	return strings.TrimRight(*baseURL, "/") + "/oauth/" + p.Name() + "/callback"
+}
+
+// pkceVerifier returns a code verifier (RFC 7636) and its S256 challenge
+func pkceVerifier() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	verifier := base64.RawURLEncoding.EncodeToString(b)
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func setStateCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    value,
+		Path:     "/oauth/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   *secureCookie,
+		SameSite: http.SameSiteLaxMode, // the callback is a top level redirect from the provider
+	})
+}
+
+// OAuthLoginHandler sends the browser to the provider. A logged in user comes
+// back with the provider account linked to theirs.
+func (h *Handler) OAuthLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	p := h.provider(r.PathValue("provider"))
+	if p == nil {
+		db.HandleError(w, http.StatusNotFound, "Unknown login provider")
+		return
+	}
+
+	state, nonce := randomToken(), randomToken()
+	verifier, challenge, err := pkceVerifier()
+	if state == "" || nonce == "" || err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	authURL, err := p.AuthCodeURL(r.Context(), state, nonce, challenge, redirectURI(p))
+	if err != nil {
+		log.Printf("OAuth %s: %v", p.Name(), err)
+		db.HandleError(w, http.StatusBadGateway, p.Title()+" login is not available right now")
+		return
+	}
+
+	setStateCookie(w, state+"."+verifier+"."+nonce, int(stateTTL.Seconds()))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OAuthCallbackHandler finishes a login or a link when the provider sends the browser back
+func (h *Handler) OAuthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	p := h.provider(r.PathValue("provider"))
+	if p == nil {
+		db.HandleError(w, http.StatusNotFound, "Unknown login provider")
+		return
+	}
+	userData, _ := r.Context().Value(UserKey).(ContextUser)
+
+	// the state proves this browser started the login, so nobody can log a
+	// victim into their own account by sending them a callback link
+	cookie, err := r.Cookie(stateCookieName)
+	setStateCookie(w, "", -1)
+	if err != nil {
+		db.HandleError(w, http.StatusBadRequest, "Your login took too long, please try again")
+		return
+	}
+	parts := strings.Split(cookie.Value, ".")
+	state := r.URL.Query().Get("state")
+	if len(parts) != 3 || state == "" || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(state)) != 1 {
+		db.HandleError(w, http.StatusBadRequest, "Invalid login state, please try again")
+		return
+	}
+	if reason := r.URL.Query().Get("error"); reason != "" {
+		log.Printf("OAuth %s refused: %s", p.Name(), reason)
+		db.HandleError(w, http.StatusUnauthorized, p.Title()+" login was cancelled")
+		return
+	}
+
+	profile, err := p.Exchange(r.Context(), r.URL.Query().Get("code"), parts[1], parts[2], redirectURI(p))
+	if err != nil {
+		log.Printf("OAuth %s: %v", p.Name(), err)
+		db.HandleError(w, http.StatusBadGateway, p.Title()+" login failed, please try again")
+		return
+	}
+	profile.Email = strings.ToLower(strings.TrimSpace(profile.Email))
+
+	userID, status, msg := h.oauthUser(userData, p, profile)
+	if status != 0 {
+		db.HandleError(w, status, msg)
+		return
+	}
+
+	if userData.LoggedIn {
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
+	h.finishLogin(w, r, userID, false)
+}
+
+// oauthUser finds the user a provider account logs in as, linking or creating
+// one when needed, and returns the status and message to show when it cannot
+func (h *Handler) oauthUser(userData ContextUser, p Provider, profile *Profile) (int, int, string) {
+	identity, err := h.Identities.Get(p.Name(), profile.Subject)
+	if err == nil {
+		if userData.LoggedIn && identity.UserID != userData.UserID {
+			return 0, http.StatusConflict, "This " + p.Title() + " account is linked to another user"
+		}
+		return identity.UserID, 0, ""
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return 0, http.StatusInternalServerError, "Internal server error"
+	}
+
+	link := &store.Identity{Provider: p.Name(), Subject: profile.Subject, Email: profile.Email}
+
+	// a logged in user links the account to theirs
+	if userData.LoggedIn {
+		link.UserID = userData.UserID
+		if err := h.Identities.Create(link); err != nil {
+			return 0, http.StatusConflict, "You already linked another " + p.Title() + " account"
+		}
+		return userData.UserID, 0, ""
+	}
+
+	// otherwise the email decides, but only one both sides verified: an
+	// unverified address could belong to someone waiting for its owner
+	if profile.Email == "" || !profile.EmailVerified {
+		return 0, http.StatusForbidden, "Your " + p.Title() + " account has no verified email address"
+	}
+	user, err := h.Users.GetByEmail(profile.Email)
+	switch {
+	case err == nil && user.EmailVerifiedAt == nil:
+		return 0, http.StatusConflict, "An account with this email exists but is not verified. " +
+			"Log in with your password and link " + p.Title() + " from your account page."
+	case err == nil:
+		link.UserID = user.ID
+	case errors.Is(err, store.ErrNotFound):
+		if link.UserID, err = h.createOAuthUser(profile); err != nil {
+			log.Printf("Failed to create user from %s: %v", p.Name(), err)
+			return 0, http.StatusInternalServerError, "Registration failed"
+		}
+	default:
+		return 0, http.StatusInternalServerError, "Internal server error"
+	}
+
+	if err := h.Identities.Create(link); err != nil {
+		return 0, http.StatusInternalServerError, "Internal server error"
+	}
+	return link.UserID, 0, ""
+}
+
+// createOAuthUser registers the profile with a verified email and no password,
+// one can be set later through the forgot password form
+func (h *Handler) createOAuthUser(profile *Profile) (int, error) {
+	username, err := h.freeUsername(profile.Username)
+	if err != nil {
+		return 0, err
+	}
+	userID, err := h.Users.Create(username, profile.Email, "")
+	if err != nil {
+		return 0, err
+	}
+	return userID, h.Users.MarkEmailVerified(userID, time.Now())
+}
+
+// freeUsername turns the provider's name for the user into an unused username
+// of 5 to 25 letters, digits, dashes and underscores
+func (h *Handler) freeUsername(name string) (string, error) {
+	base := strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-':
+			return c
+		case c == ' ' || c == '.':
+			return '_'
+		}
+		return -1
+	}, name)
+	if len(base) > 20 {
+		base = base[:20]
+	}
+	if len(base) < 5 {
+		base = "user_" + base
+	}
+
+	candidate := base
+	for i := 0; i < 10; i++ {
+		taken, err := h.Users.UsernameExists(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+		suffix := randomToken()
+		if suffix == "" {
+			break
+		}
+		candidate = base + "_" + suffix[:4]
+	}
+	return "", errors.New("no free username for " + base)
+}
+
+// UnlinkIdentityHandler removes a provider account from the current user, unless
+// it is their only way to log in
+func (h *Handler) UnlinkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(UserKey).(ContextUser)
+	provider := r.FormValue("provider")
+
+	user, err := h.Users.GetByID(userData.UserID)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	identities, err := h.Identities.ListByUser(userData.UserID)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if user.PasswordHash == "" && len(identities) <= 1 {
+		db.HandleError(w, http.StatusBadRequest,
+			"This is your only way to log in. Set a password with the forgot password form first.")
+		return
+	}
+
+	if err := h.Identities.Delete(userData.UserID, provider); err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
@@ -0,0 +1,337 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	googleClientID     = flag.String("google-client-id", "", "OAuth client id for Google login, off when empty")
+	googleClientSecret = flag.String("google-client-secret", "", "OAuth client secret for Google, defaults to the GOOGLE_CLIENT_SECRET environment variable")
+	googleIssuer       = flag.String("google-issuer", "https://accounts.google.com", "OpenID Connect issuer of the Google provider, its endpoints are discovered")
+	githubClientID     = flag.String("github-client-id", "", "OAuth client id for GitHub login, off when empty")
+	githubClientSecret = flag.String("github-client-secret", "", "OAuth client secret for GitHub, defaults to the GITHUB_CLIENT_SECRET environment variable")
+	githubURL          = flag.String("github-url", "https://github.com", "Where GitHub serves its OAuth endpoints")
+	githubAPIURL       = flag.String("github-api-url", "https://api.github.com", "Where GitHub serves its REST API")
+)
+
+// oauthClient talks to the providers, a stuck provider must not hold a login forever
+var oauthClient = &http.Client{Timeout: 10 * time.Second}
+
+// ProvidersFromFlags returns the providers that have a client id configured
+func ProvidersFromFlags() []Provider {
+	var providers []Provider
+	if *googleClientID != "" {
+		providers = append(providers, &OIDCProvider{
+			ID:           "google",
+			DisplayName:  "Google",
+			Issuer:       *googleIssuer,
+			ClientID:     *googleClientID,
+			ClientSecret: secretOrEnv(*googleClientSecret, "GOOGLE_CLIENT_SECRET"),
+		})
+	}
+	if *githubClientID != "" {
+		providers = append(providers, &GitHubProvider{
+			BaseURL:      *githubURL,
+			APIURL:       *githubAPIURL,
+			ClientID:     *githubClientID,
+			ClientSecret: secretOrEnv(*githubClientSecret, "GITHUB_CLIENT_SECRET"),
+		})
+	}
+	return providers
+}
+
+func secretOrEnv(secret, env string) string {
+	if secret == "" {
+		return os.Getenv(env)
+	}
+	return secret
+}
+
+// OIDCProvider is an OpenID Connect provider, like Google, whose endpoints
+// are discovered from its issuer
+type OIDCProvider struct {
+	ID           string
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+func (p *OIDCProvider) Name() string  { return p.ID }
+func (p *OIDCProvider) Title() string { return p.DisplayName }
+
+// discover reads the provider configuration once it answered
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	d := &oidcDiscovery{}
+	configURL := strings.TrimRight(p.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(ctx, configURL, "", d); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	if d.Issuer != p.Issuer {
+		return nil, fmt.Errorf("discovery: issuer %q does not match %q", d.Issuer, p.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" {
+		return nil, errors.New("discovery: missing endpoints")
+	}
+	p.discovery = d
+	return d, nil
+}
+
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, challenge, redirectURI string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", "openid email profile")
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", challenge)
+	q.Set("code_challenge_method", "S256")
+	return withQuery(d.AuthorizationEndpoint, q), nil
+}
+
+// idTokenClaims are the claims of the ID token the forum uses
+type idTokenClaims struct {
+	Issuer            string          `json:"iss"`
+	Subject           string          `json:"sub"`
+	Audience          json.RawMessage `json:"aud"`
+	Expiry            int64           `json:"exp"`
+	Nonce             string          `json:"nonce"`
+	Email             string          `json:"email"`
+	EmailVerified     json.RawMessage `json:"email_verified"`
+	Name              string          `json:"name"`
+	PreferredUsername string          `json:"preferred_username"`
+}
+
+// Exchange trades the code for an ID token. The token comes straight from the
+// token endpoint over TLS, which OpenID Connect Core 3.1.3.7 accepts in place of
+// checking its signature; its issuer, audience, expiry and nonce are checked.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce, redirectURI string) (*Profile, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	form.Set("code_verifier", verifier)
+	if err := postForm(ctx, d.TokenEndpoint, form, &token); err != nil {
+		return nil, err
+	}
+	if token.Error != "" || token.IDToken == "" {
+		return nil, fmt.Errorf("token endpoint: %q", token.Error)
+	}
+
+	parts := strings.Split(token.IDToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token: %w", err)
+	}
+	var claims idTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token: %w", err)
+	}
+
+	switch {
+	case claims.Issuer != d.Issuer:
+		return nil, fmt.Errorf("ID token from issuer %q", claims.Issuer)
+	case !hasAudience(claims.Audience, p.ClientID):
+		return nil, errors.New("ID token for another client")
+	case time.Now().Unix() >= claims.Expiry:
+		return nil, errors.New("expired ID token")
+	case claims.Nonce != nonce:
+		return nil, errors.New("ID token nonce does not match")
+	case claims.Subject == "":
+		return nil, errors.New("ID token without subject")
+	}
+
+	// some providers send email_verified as a string
+	verified := string(claims.EmailVerified) == "true" || string(claims.EmailVerified) == `"true"`
+	username := claims.PreferredUsername
+	if username == "" {
+		username = claims.Name
+	}
+	return &Profile{Subject: claims.Subject, Email: claims.Email, EmailVerified: verified, Username: username}, nil
+}
+
+// hasAudience reads aud, which is a string or a list of strings
+func hasAudience(raw json.RawMessage, clientID string) bool {
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return one == clientID
+	}
+	var many []string
+	if json.Unmarshal(raw, &many) != nil {
+		return false
+	}
+	for _, aud := range many {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// GitHubProvider logs in with GitHub, which speaks OAuth2 but not OpenID Connect,
+// so the profile comes from its REST API
+type GitHubProvider struct {
+	BaseURL      string
+	APIURL       string
+	ClientID     string
+	ClientSecret string
+}
+
+func (p *GitHubProvider) Name() string  { return "github" }
+func (p *GitHubProvider) Title() string { return "GitHub" }
+
+func (p *GitHubProvider) AuthCodeURL(ctx context.Context, state, nonce, challenge, redirectURI string) (string, error) {
+	q := url.Values{}
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", "read:user user:email")
+	q.Set("state", state)
+	q.Set("code_challenge", challenge)
+	q.Set("code_challenge_method", "S256")
+	return withQuery(strings.TrimRight(p.BaseURL, "/")+"/login/oauth/authorize", q), nil
+}
+
+func (p *GitHubProvider) Exchange(ctx context.Context, code, verifier, nonce, redirectURI string) (*Profile, error) {
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	form := url.Values{}
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("code_verifier", verifier)
+	if err := postForm(ctx, strings.TrimRight(p.BaseURL, "/")+"/login/oauth/access_token", form, &token); err != nil {
+		return nil, err
+	}
+	if token.Error != "" || token.AccessToken == "" {
+		return nil, fmt.Errorf("token endpoint: %q", token.Error)
+	}
+
+	api := strings.TrimRight(p.APIURL, "/")
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+	}
+	if err := getJSON(ctx, api+"/user", token.AccessToken, &user); err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, errors.New("GitHub user without id")
+	}
+
+	// the public profile email may be missing or unverified, the primary one is not
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, api+"/user/emails", token.AccessToken, &emails); err != nil {
+		return nil, err
+	}
+	profile := &Profile{Subject: strconv.FormatInt(user.ID, 10), Username: user.Login}
+	for _, e := range emails {
+		if e.Primary {
+			profile.Email, profile.EmailVerified = e.Email, e.Verified
+		}
+	}
+	return profile, nil
+}
+
+func withQuery(endpoint string, q url.Values) string {
+	if strings.Contains(endpoint, "?") {
+		return endpoint + "&" + q.Encode()
+	}
+	return endpoint + "?" + q.Encode()
+}
+
+func postForm(ctx context.Context, endpoint string, form url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	return doJSON(req, out)
+}
+
+func getJSON(ctx context.Context, endpoint, accessToken string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return doJSON(req, out)
+}
+
+func doJSON(req *http.Request, out interface{}) error {
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	// token endpoints answer errors with 400 and a JSON error, let the caller see it
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("%s %s: %w", req.Method, req.URL.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"forum/internal/store"
+)
+
+// fakeOIDC is an OpenID Connect provider on a local server: it serves discovery,
+// an authorization endpoint that approves every login and a token endpoint that
+// checks PKCE and answers with an unsigned ID token
+type fakeOIDC struct {
+	server   *httptest.Server
+	clientID string
+
+	// the account logging in
+	subject       string
+	email         string
+	emailVerified bool
+	// claims lets a test change the claims of the ID token before it is sent
+	claims func(map[string]interface{})
+
+	mu     sync.Mutex
+	grants map[string]fakeGrant
+}
+
+// fakeGrant is what the provider remembers of an authorization until the code is traded
+type fakeGrant struct {
+	nonce     string
+	challenge string
+}
+
+func newFakeOIDC(t *testing.T) *fakeOIDC {
+	f := &fakeOIDC{clientID: "forum-test", subject: "fake-1", email: "ada@example.com", emailVerified: true, grants: make(map[string]fakeGrant)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.server.URL,
+			"authorization_endpoint": f.server.URL + "/authorize",
+			"token_endpoint":         f.server.URL + "/token",
+		})
+	})
+	mux.HandleFunc("/authorize", f.authorize)
+	mux.HandleFunc("/token", f.token)
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeOIDC) provider() *OIDCProvider {
+	return &OIDCProvider{ID: "fake", DisplayName: "Fake", Issuer: f.server.URL, ClientID: f.clientID, ClientSecret: "secret"}
+}
+
+func (f *fakeOIDC) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != f.clientID || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad authorization request", http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	code := "code-" + strconv.Itoa(len(f.grants)+1)
+	f.grants[code] = fakeGrant{nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+	f.mu.Unlock()
+
+	back := url.Values{}
+	back.Set("code", code)
+	back.Set("state", q.Get("state"))
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+back.Encode(), http.StatusFound)
+}
+
+func (f *fakeOIDC) token(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	grant, ok := f.grants[r.FormValue("code")]
+	delete(f.grants, r.FormValue("code"))
+	f.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge || r.FormValue("client_id") != f.clientID {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss":            f.server.URL,
+		"sub":            f.subject,
+		"aud":            f.clientID,
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+		"nonce":          grant.nonce,
+		"email":          f.email,
+		"email_verified": f.emailVerified,
+		"name":           "Ada Lovelace",
+	}
+	if f.claims != nil {
+		f.claims(claims)
+	}
+	payload, _ := json.Marshal(claims)
+	idToken := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString(payload) + "."
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "access_token": "access", "token_type": "Bearer"})
+}
+
+// oauthRoutes serves the login and callback handlers under their paths
+func oauthRoutes(h *Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/{provider}/login", h.OAuthLoginHandler)
+	mux.HandleFunc("/oauth/{provider}/callback", h.OAuthCallbackHandler)
+	return mux
+}
+
+// startLogin starts a login at the forum and lets the browser through the provider,
+// returning the callback request the provider sends it back with
+func startLogin(t *testing.T, routes http.Handler) *http.Request {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/oauth/fake/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: status %d, body %s", rec.Code, rec.Body)
+	}
+	var state *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == stateCookieName {
+			state = c
+		}
+	}
+	if state == nil {
+		t.Fatal("login: no state cookie")
+	}
+
+	browser := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := browser.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %d, location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	req.AddCookie(state)
+	return req
+}
+
+// finish sends the callback and returns the response with the user the session belongs to, 0 without one
+func finish(t *testing.T, routes http.Handler, stores *store.Stores, req *http.Request) (*httptest.ResponseRecorder, int) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, req)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == SessionCookieName && c.Value != "" {
+			session, err := stores.Sessions.Get(c.Value)
+			if err != nil {
+				t.Fatalf("session cookie without a session: %v", err)
+			}
+			return rec, session.UserID
+		}
+	}
+	return rec, 0
+}
+
+func TestOAuthLoginCreatesUser(t *testing.T) {
+	fake := newFakeOIDC(t)
+	h, stores := newTestHandler(t, fake.provider())
+	routes := oauthRoutes(h)
+
+	rec, userID := finish(t, routes, stores, startLogin(t, routes))
+	if rec.Code != http.StatusSeeOther || userID == 0 {
+		t.Fatalf("callback: status %d, user %d, body %s", rec.Code, userID, rec.Body)
+	}
+	user, err := stores.Users.GetByID(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != fake.email || user.EmailVerifiedAt == nil || user.PasswordHash != "" {
+		t.Errorf("created user %+v, want %s verified without a password", user, fake.email)
+	}
+
+	// the next login finds the linked identity
+	_, again := finish(t, routes, stores, startLogin(t, routes))
+	if again != userID {
+		t.Errorf("second login as user %d, want %d", again, userID)
+	}
+}
+
+func TestOAuthCallbackRejectsState(t *testing.T) {
+	fake := newFakeOIDC(t)
+	h, stores := newTestHandler(t, fake.provider())
+	routes := oauthRoutes(h)
+
+	req := startLogin(t, routes)
+	q := req.URL.Query()
+	q.Set("state", "forged")
+	req.URL.RawQuery = q.Encode()
+	if rec, userID := finish(t, routes, stores, req); rec.Code != http.StatusBadRequest || userID != 0 {
+		t.Errorf("forged state: status %d, user %d", rec.Code, userID)
+	}
+
+	// a callback link sent to someone who never started a login
+	req = startLogin(t, routes)
+	bare := httptest.NewRequest(http.MethodGet, req.URL.RequestURI(), nil)
+	if rec, userID := finish(t, routes, stores, bare); rec.Code != http.StatusBadRequest || userID != 0 {
+		t.Errorf("no state cookie: status %d, user %d", rec.Code, userID)
+	}
+}
+
+func TestOAuthCallbackRejectsIDToken(t *testing.T) {
+	cases := []struct {
+		name   string
+		claims func(map[string]interface{})
+	}{
+		{"nonce mismatch", func(c map[string]interface{}) { c["nonce"] = "replayed" }},
+		{"wrong audience", func(c map[string]interface{}) { c["aud"] = "another-client" }},
+		{"audience list without us", func(c map[string]interface{}) { c["aud"] = []string{"a", "b"} }},
+		{"wrong issuer", func(c map[string]interface{}) { c["iss"] = "https://evil.example" }},
+		{"expired", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{"no subject", func(c map[string]interface{}) { delete(c, "sub") }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := newFakeOIDC(t)
+			fake.claims = tc.claims
+			h, stores := newTestHandler(t, fake.provider())
+			routes := oauthRoutes(h)
+
+			rec, userID := finish(t, routes, stores, startLogin(t, routes))
+			if rec.Code != http.StatusBadGateway || userID != 0 {
+				t.Errorf("status %d, user %d, want %d and no session", rec.Code, userID, http.StatusBadGateway)
+			}
+			if _, err := stores.Users.GetByEmail(fake.email); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("a user was created: %v", err)
+			}
+		})
+	}
+}
+
+func TestOAuthLinksVerifiedEmail(t *testing.T) {
+	fake := newFakeOIDC(t)
+	h, stores := newTestHandler(t, fake.provider())
+	routes := oauthRoutes(h)
+
+	userID, err := stores.Users.Create("ada_l", fake.email, "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stores.Users.MarkEmailVerified(userID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, loggedIn := finish(t, routes, stores, startLogin(t, routes))
+	if rec.Code != http.StatusSeeOther || loggedIn != userID {
+		t.Fatalf("callback: status %d, user %d, want user %d", rec.Code, loggedIn, userID)
+	}
+	identity, err := stores.Identities.Get("fake", fake.subject)
+	if err != nil || identity.UserID != userID {
+		t.Errorf("identity %+v, %v, want it linked to user %d", identity, err, userID)
+	}
+}
+
+func TestOAuthRefusesUnverifiedEmail(t *testing.T) {
+	t.Run("unverified at the provider", func(t *testing.T) {
+		fake := newFakeOIDC(t)
+		fake.emailVerified = false
+		h, stores := newTestHandler(t, fake.provider())
+		routes := oauthRoutes(h)
+
+		userID, err := stores.Users.Create("ada_l", fake.email, "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stores.Users.MarkEmailVerified(userID, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		rec, loggedIn := finish(t, routes, stores, startLogin(t, routes))
+		if rec.Code != http.StatusForbidden || loggedIn != 0 {
+			t.Errorf("status %d, user %d, want %d and no session", rec.Code, loggedIn, http.StatusForbidden)
+		}
+		if _, err := stores.Identities.Get("fake", fake.subject); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("identity linked: %v", err)
+		}
+	})
+
+	t.Run("unverified at the forum", func(t *testing.T) {
+		fake := newFakeOIDC(t)
+		h, stores := newTestHandler(t, fake.provider())
+		routes := oauthRoutes(h)
+
+		if _, err := stores.Users.Create("ada_l", fake.email, "hash"); err != nil {
+			t.Fatal(err)
+		}
+		rec, loggedIn := finish(t, routes, stores, startLogin(t, routes))
+		if rec.Code != http.StatusConflict || loggedIn != 0 {
+			t.Errorf("status %d, user %d, want %d and no session", rec.Code, loggedIn, http.StatusConflict)
+		}
+		if _, err := stores.Identities.Get("fake", fake.subject); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("identity linked: %v", err)
+		}
+	})
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	db "forum/internal/database"
+	"forum/internal/mail"
+	"forum/internal/store"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var resetTTL = flag.Duration("reset-ttl", time.Hour, "How long a password reset link stays valid")
+
+// ForgotPasswordHandler mails a reset link to the address, if it has an account.
+// The answer is the same either way so the form does not tell which emails are registered.
+func (h *Handler) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		db.RenderTemplate(w, "forgot_password", map[string]interface{}{
+			"Title": "Forgot Password",
+		})
+		return
+	}
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.FormValue("email")))
+
+	// every request counts, so the form cannot be used to flood mailboxes
+	key := resetKey(clientIP(r))
+	wait, err := h.Limiter.Wait(key)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if wait > 0 {
+		h.Limiter.Audit("reset", r, email, "locked out")
+		retryAfter(w, wait)
+		db.RenderTemplate(w, "forgot_password", map[string]interface{}{
+			"Title": "Forgot Password",
+			"Email": email,
+			"Error": lockoutMessage(wait),
+		})
+		return
+	}
+	if err := h.Limiter.Hit(key); err != nil {
+		log.Printf("Failed to throttle password reset: %v", err)
+	}
+
+	user, err := h.Users.GetByEmail(email)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		h.Limiter.Audit("reset", r, email, "unknown email")
+	case err != nil:
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	default:
+		if err := h.sendResetLink(user); err != nil {
+			log.Printf("Failed to send password reset to user %d: %v", user.ID, err)
+		}
+	}
+
+	db.RenderTemplate(w, "forgot_password", map[string]interface{}{
+		"Title": "Forgot Password",
+		"Sent":  true,
+	})
+}
+
+// sendResetLink stores a new reset token for the user and mails its link
+func (h *Handler) sendResetLink(user *store.User) error {
+	token := randomToken()
+	if token == "" {
+		return errors.New("no randomness for the reset token")
+	}
+	if err := h.Resets.Create(hashToken(token), user.ID, time.Now().Add(*resetTTL)); err != nil {
+		return err
+	}
+
+	link := strings.TrimRight(*baseURL, "/") + "/reset-password?token=" + url.QueryEscape(token)
+	return h.Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your forum password",
+		Body: fmt.Sprintf("Hello %s,\n\nSomeone asked to reset the password of your account. "+
+			"If it was you, open this link within %d minutes to choose a new one:\n\n%s\n\n"+
+			"If it was not, you can ignore this mail, your password stays the same.",
+			user.Username, int(resetTTL.Minutes()), link),
+	})
+}
+
+// ResetPasswordHandler sets a new password from a mailed link, then ends every
+// session of the account
+func (h *Handler) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	now := time.Now()
+
+	if r.Method == http.MethodGet {
+		if _, err := h.Resets.UserID(hashToken(token), now); err != nil {
+			h.invalidResetLink(w, err)
+			return
+		}
+		db.RenderTemplate(w, "reset_password", map[string]interface{}{
+			"Title": "Reset Password",
+			"Token": token,
+		})
+		return
+	}
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+
+	password := strings.TrimSpace(r.FormValue("password"))
+	var msg string
+	if len(password) < 5 || len(password) > 25 {
+		msg = "Password must be between 5 and 25 characters"
+	} else if password != strings.TrimSpace(r.FormValue("confirm")) {
+		msg = "Passwords do not match"
+	}
+	if msg != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		db.RenderTemplate(w, "reset_password", map[string]interface{}{
+			"Title": "Reset Password",
+			"Token": token,
+			"Error": msg,
+		})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Failed to hash password")
+		return
+	}
+
+	userID, err := h.Resets.Consume(hashToken(token), now)
+	if err != nil {
+		h.invalidResetLink(w, err)
+		return
+	}
+	user, err := h.Users.GetByID(userID)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if err := h.Users.UpdatePassword(userID, string(hashedPassword)); err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Failed to update password")
+		return
+	}
+
+	// whoever had the old password is logged out, and the other links die with it
+	if err := h.Sessions.DeleteByUser(userID); err != nil {
+		log.Printf("Failed to end sessions after password reset: %v", err)
+	}
+	if err := h.Resets.DeleteByUser(userID); err != nil {
+		log.Printf("Failed to delete reset tokens: %v", err)
+	}
+	if err := h.Limiter.Reset(accountKey(user.Email)); err != nil {
+		log.Printf("Failed to reset login throttle: %v", err)
+	}
+
+	expireSessionCookie(w)
+	http.Redirect(w, r, "/login?reset=1", http.StatusSeeOther)
+}
+
+func (h *Handler) invalidResetLink(w http.ResponseWriter, err error) {
+	if !errors.Is(err, store.ErrNotFound) {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	db.RenderTemplate(w, "reset_password", map[string]interface{}{
+		"Title":   "Reset Password",
+		"Invalid": true,
+	})
+}
@@ -6,12 +6,13 @@ import (
 	"strings"
 
 	db "forum/internal/database"
+	"forum/internal/store"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 // Register a new user
-func RegisterHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	var cred Credentials
 
 	if r.Method == http.MethodGet {
@@ -33,8 +34,8 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 			cred.Error.Username = "Username must be between 5 and 25 characters"
 			valid = false
 		}
-		if len(cred.Email) < 5 || len(cred.Email) > 25 {
-			cred.Error.Email = "Email must be between 5 and 25 characters"
+		if !validEmail(cred.Email) {
+			cred.Error.Email = "Enter a valid email address, like name@example.com"
 			valid = false
 		}
 		if len(cred.Password) < 5 || len(cred.Password) > 25 {
@@ -51,15 +52,36 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		var count int
-		err := db.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = ?", cred.Email).Scan(&count)
+		// every registration from a client IP counts, so accounts cannot be created in bulk
+		ip := clientIP(r)
+		wait, err := h.Limiter.Wait(registerKey(ip))
+		if err != nil {
+			db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if wait > 0 {
+			h.Limiter.Audit("register", r, cred.Email, "locked out")
+			retryAfter(w, wait)
+			cred.Error.Form = lockoutMessage(wait)
+			db.RenderTemplate(w, "register", map[string]interface{}{
+				"Title":       "Registration",
+				"Credentials": cred,
+			})
+			return
+		}
+		if err := h.Limiter.Hit(registerKey(ip)); err != nil {
+			log.Printf("Failed to throttle registration: %v", err)
+		}
+
+		exists, err := h.Users.EmailExists(cred.Email)
 		if err != nil {
 			log.Printf("Error checking email uniqueness: %v", err)
 			db.HandleError(w, http.StatusInternalServerError, "Database error")
 
 			return
 		}
-		if count > 0 {
+		if exists {
+			h.Limiter.Audit("register", r, cred.Email, "email in use")
 			w.WriteHeader(http.StatusBadRequest)
 			cred.Error.Email = "Email already in use"
 			db.RenderTemplate(w, "register", map[string]interface{}{
@@ -76,18 +98,17 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Insert the new user into the database
-		prep, err := db.DB.Prepare("INSERT INTO users (username, email, password) VALUES (?, ?, ?)")
+		userID, err := h.Users.Create(cred.Username, cred.Email, string(hashedPassword))
 		if err != nil {
-			db.HandleError(w, http.StatusInternalServerError, "Prep error")
+			db.HandleError(w, http.StatusInternalServerError, "registration failed")
 
 			return
 		}
-		defer prep.Close()
 
-		if _, err = prep.Exec(cred.Username, cred.Email, hashedPassword); err != nil {
-			db.HandleError(w, http.StatusInternalServerError, "registration failed")
-
-			return
+		// the account is usable right away, the link proves the address for posting
+		user := &store.User{ID: userID, Username: cred.Username, Email: cred.Email}
+		if err := h.sendVerification(user); err != nil {
+			log.Printf("Failed to send verification to user %d: %v", userID, err)
 		}
 
 		// Redirect to login page or success page
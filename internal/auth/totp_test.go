@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of RFC 6238 Appendix B, "12345678901234567890" in ASCII
+var rfc6238Secret = base32NoPad.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	// the RFC lists 8 digit codes, the last 6 digits are the 6 digit ones
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, v := range vectors {
+		got, err := totpCode(rfc6238Secret, totpStep(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatalf("totpCode at %d: %v", v.unix, err)
+		}
+		if want := v.code[2:]; got != want {
+			t.Errorf("totpCode at %d = %s, want %s", v.unix, got, want)
+		}
+	}
+}
+
+func TestTOTPSecretIsBase32(t *testing.T) {
+	secret := newTOTPSecret()
+	key, err := base32NoPad.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("newTOTPSecret() = %q, want 20 bytes in base32: %v", secret, err)
+	}
+}
+
+func TestMatchTOTPWindow(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := totpStep(now)
+	for offset := int64(-3); offset <= 3; offset++ {
+		code, err := totpCode(rfc6238Secret, current+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step, ok := matchTOTP(rfc6238Secret, code, now)
+		inWindow := offset >= -totpSkew && offset <= totpSkew
+		if ok != inWindow {
+			t.Errorf("code %d steps off: accepted = %v, want %v", offset, ok, inWindow)
+		}
+		if ok && step != current+offset {
+			t.Errorf("code %d steps off: matched step %d, want %d", offset, step, current+offset)
+		}
+	}
+}
+
+func TestMatchTOTPInput(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, err := totpCode(rfc6238Secret, totpStep(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// people type codes with spaces
+	if _, ok := matchTOTP(rfc6238Secret, " "+code[:3]+" "+code[3:]+" ", now); !ok {
+		t.Errorf("code with spaces was refused")
+	}
+	for _, bad := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := matchTOTP(rfc6238Secret, bad, now); ok {
+			t.Errorf("matchTOTP(%q) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	db "forum/internal/database"
+	"forum/internal/store"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// pendingCookieName holds a login that passed the password, it is not a
+	// session so AuthMiddleware and RequireAuth never accept it
+	pendingCookieName = "pending_2fa"
+	pendingLoginTTL   = 5 * time.Minute
+)
+
+// startPendingLogin remembers for a few minutes that the user passed the password
+func (h *Handler) startPendingLogin(w http.ResponseWriter, userID int, remember bool) error {
+	token := randomToken()
+	if token == "" {
+		return errors.New("no randomness for the pending login")
+	}
+	expiresAt := time.Now().Add(pendingLoginTTL)
+	pending := &store.PendingLogin{TokenHash: hashToken(token), UserID: userID, Remember: remember, ExpiresAt: expiresAt}
+	if err := h.PendingLogins.Create(pending); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     pendingCookieName,
+		Value:    token,
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   *secureCookie,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/login",
+	})
+	return nil
+}
+
+func expirePendingCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     pendingCookieName,
+		Value:    "",
+		Path:     "/login",
+		Expires:  time.Now().Add(-1 * time.Hour),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   *secureCookie,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// checkSecondFactor accepts a TOTP code once, or an unused recovery code
+func (h *Handler) checkSecondFactor(userID int, code string) (ok, recovery bool, err error) {
+	totp, err := h.TwoFactor.Get(userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, false, nil
+	}
+	if err != nil || totp.EnabledAt == nil {
+		return false, false, err
+	}
+
+	now := time.Now()
+	if step, match := matchTOTP(totp.Secret, code, now); match {
+		ok, err := h.TwoFactor.UseStep(userID, step)
+		return ok, false, err
+	}
+	if len(strings.TrimSpace(code)) <= totpDigits {
+		return false, false, nil
+	}
+	err = h.TwoFactor.UseRecoveryCode(userID, hashRecoveryCode(code), now)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, false, nil
+	}
+	return err == nil, true, err
+}
+
+// LoginTwoFactorHandler is the second login step of users with 2FA, between the
+// password and the session
+func (h *Handler) LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+
+	var pending *store.PendingLogin
+	cookie, err := r.Cookie(pendingCookieName)
+	if err == nil {
+		pending, err = h.PendingLogins.Get(hashToken(cookie.Value), time.Now())
+	}
+	if err != nil && !errors.Is(err, http.ErrNoCookie) && !errors.Is(err, store.ErrNotFound) {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if pending == nil {
+		// no password step or it took too long, start over
+		expirePendingCookie(w)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		db.RenderTemplate(w, "login_two_factor", map[string]interface{}{
+			"Title": "Two-Factor Login",
+		})
+		return
+	}
+
+	// throttled per account, so a stolen password does not allow guessing codes
+	key := twoFactorKey(pending.UserID)
+	wait, err := h.Limiter.Wait(key)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	email := ""
+	if user, err := h.Users.GetByID(pending.UserID); err == nil {
+		email = user.Email
+	}
+	if wait > 0 {
+		h.Limiter.Audit("2fa", r, email, "locked out")
+		retryAfter(w, wait)
+		db.RenderTemplate(w, "login_two_factor", map[string]interface{}{
+			"Title": "Two-Factor Login",
+			"Error": lockoutMessage(wait),
+		})
+		return
+	}
+
+	ok, recovery, err := h.checkSecondFactor(pending.UserID, r.FormValue("code"))
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if !ok {
+		if err := h.Limiter.Hit(key); err != nil {
+			log.Printf("Failed to throttle 2FA login: %v", err)
+		}
+		h.Limiter.Audit("2fa", r, email, "wrong code")
+		w.WriteHeader(http.StatusBadRequest)
+		db.RenderTemplate(w, "login_two_factor", map[string]interface{}{
+			"Title": "Two-Factor Login",
+			"Error": "Invalid or already used code",
+		})
+		return
+	}
+
+	if err := h.PendingLogins.Delete(pending.TokenHash); err != nil {
+		log.Printf("Failed to delete pending login: %v", err)
+	}
+	expirePendingCookie(w)
+	if err := h.Limiter.Reset(key); err != nil {
+		log.Printf("Failed to reset 2FA throttle: %v", err)
+	}
+	if err := h.startSession(w, r, pending.UserID, pending.Remember); err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if recovery {
+		// show how many recovery codes are left
+		http.Redirect(w, r, "/two-factor", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// renderTwoFactor renders the 2FA settings of the current user with the extra data
+func (h *Handler) renderTwoFactor(w http.ResponseWriter, userData ContextUser, data map[string]interface{}) {
+	user, err := h.Users.GetByID(userData.UserID)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	// users who signed up with a provider confirm changes with a code instead
+	data["NoPassword"] = user.PasswordHash == ""
+	data["Title"] = "Two-Factor Authentication"
+	data["LoggedIn"] = userData.LoggedIn
+	data["Username"] = userData.Username
+	db.RenderTemplate(w, "two_factor", data)
+}
+
+// TwoFactorHandler shows whether 2FA is on, or the secret to enroll an authenticator app
+func (h *Handler) TwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(UserKey).(ContextUser)
+
+	totp, err := h.TwoFactor.Get(userData.UserID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if err == nil && totp.EnabledAt != nil {
+		left, err := h.TwoFactor.RecoveryCodesLeft(userData.UserID)
+		if err != nil {
+			db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		h.renderTwoFactor(w, userData, map[string]interface{}{
+			"Enabled":   true,
+			"EnabledAt": totp.EnabledAt,
+			"CodesLeft": left,
+		})
+		return
+	}
+
+	h.renderEnrollment(w, userData, totp, "")
+}
+
+// renderEnrollment shows the secret being enrolled as a QR code and a link,
+// starting a new enrollment if there is none
+func (h *Handler) renderEnrollment(w http.ResponseWriter, userData ContextUser, totp *store.TOTP, errMsg string) {
+	user, err := h.Users.GetByID(userData.UserID)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	var secret string
+	if totp != nil {
+		secret = totp.Secret
+	} else {
+		if secret = newTOTPSecret(); secret == "" {
+			db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := h.TwoFactor.SetPending(user.ID, secret); err != nil {
+			db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	uri := provisioningURI(secret, user.Email)
+	qr, err := qrDataURI(uri)
+	if err != nil {
+		log.Printf("Failed to render the QR code: %v", err)
+	}
+
+	if errMsg != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	h.renderTwoFactor(w, userData, map[string]interface{}{
+		"Secret": secret,
+		"URI":    uri,
+		"QR":     qr,
+		"Error":  errMsg,
+	})
+}
+
+// EnableTwoFactorHandler turns 2FA on once the user typed a code of the enrolled
+// secret, and shows the recovery codes this one time
+func (h *Handler) EnableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(UserKey).(ContextUser)
+
+	totp, err := h.TwoFactor.Get(userData.UserID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Redirect(w, r, "/two-factor", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if totp.EnabledAt != nil {
+		http.Redirect(w, r, "/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	step, ok := matchTOTP(totp.Secret, r.FormValue("code"), time.Now())
+	if !ok {
+		h.renderEnrollment(w, userData, totp, "That code does not match, check the clock of your device and try again")
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if err := h.TwoFactor.Enable(userData.UserID, time.Now(), step, hashes); err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		return
+	}
+
+	h.renderTwoFactor(w, userData, map[string]interface{}{
+		"Enabled":       true,
+		"CodesLeft":     len(codes),
+		"RecoveryCodes": codes,
+	})
+}
+
+// confirmChange confirms the current password of the user before 2FA changes, or
+// a current code of their authenticator app when they have no password, returning
+// the status and message to show when it does not match
+func (h *Handler) confirmChange(r *http.Request, userID int) (int, string) {
+	key := twoFactorKey(userID)
+	wait, err := h.Limiter.Wait(key)
+	if err != nil {
+		return http.StatusInternalServerError, "Internal server error"
+	}
+	if wait > 0 {
+		return http.StatusTooManyRequests, lockoutMessage(wait)
+	}
+
+	user, err := h.Users.GetByID(userID)
+	if err != nil {
+		return http.StatusInternalServerError, "Internal server error"
+	}
+	if user.PasswordHash == "" {
+		ok, err := h.checkCurrentCode(userID, r.FormValue("code"))
+		if err != nil {
+			return http.StatusInternalServerError, "Internal server error"
+		}
+		if !ok {
+			if err := h.Limiter.Hit(key); err != nil {
+				log.Printf("Failed to throttle code check: %v", err)
+			}
+			h.Limiter.Audit("2fa", r, user.Email, "wrong code")
+			return http.StatusBadRequest, "Invalid or already used code"
+		}
+		return 0, ""
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(r.FormValue("password"))); err != nil {
+		if err := h.Limiter.Hit(key); err != nil {
+			log.Printf("Failed to throttle password check: %v", err)
+		}
+		h.Limiter.Audit("2fa", r, user.Email, "wrong password")
+		return http.StatusBadRequest, "Wrong password"
+	}
+	return 0, ""
+}
+
+// checkCurrentCode accepts a TOTP code of the enabled secret once, recovery codes
+// are only for logging in
+func (h *Handler) checkCurrentCode(userID int, code string) (bool, error) {
+	totp, err := h.TwoFactor.Get(userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil || totp.EnabledAt == nil {
+		return false, err
+	}
+	step, match := matchTOTP(totp.Secret, code, time.Now())
+	if !match {
+		return false, nil
+	}
+	return h.TwoFactor.UseStep(userID, step)
+}
+
+// DisableTwoFactorHandler turns 2FA off after checking the password, or a code without one
+func (h *Handler) DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(UserKey).(ContextUser)
+
+	if status, msg := h.confirmChange(r, userData.UserID); status != 0 {
+		db.HandleError(w, status, msg)
+		return
+	}
+	if err := h.TwoFactor.Disable(userData.UserID); err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// RecoveryCodesHandler replaces the recovery codes after checking the password, or a code
+// without one, and shows the new ones this one time
+func (h *Handler) RecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(UserKey).(ContextUser)
+
+	totp, err := h.TwoFactor.Get(userData.UserID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if err != nil || totp.EnabledAt == nil {
+		http.Redirect(w, r, "/two-factor", http.StatusSeeOther)
+		return
+	}
+	if status, msg := h.confirmChange(r, userData.UserID); status != 0 {
+		db.HandleError(w, status, msg)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if err := h.TwoFactor.ReplaceRecoveryCodes(userData.UserID, hashes); err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Failed to replace the recovery codes")
+		return
+	}
+
+	h.renderTwoFactor(w, userData, map[string]interface{}{
+		"Enabled":       true,
+		"EnabledAt":     totp.EnabledAt,
+		"CodesLeft":     len(codes),
+		"RecoveryCodes": codes,
+	})
+}
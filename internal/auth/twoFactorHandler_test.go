@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// enableTwoFactor creates a user with 2FA enabled, returning its id and secret
+func enableTwoFactor(t *testing.T, h *Handler, passwordHash string) (int, string) {
+	t.Helper()
+	userID, err := h.Users.Create("ada_l", "ada@example.com", passwordHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := newTOTPSecret()
+	if err := h.TwoFactor.SetPending(userID, secret); err != nil {
+		t.Fatal(err)
+	}
+	// enabled at an old step so the current codes are still unused
+	if err := h.TwoFactor.Enable(userID, time.Now(), 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	return userID, secret
+}
+
+// currentCode is the code the authenticator app of the user shows now
+func currentCode(t *testing.T, secret string) string {
+	t.Helper()
+	code, err := totpCode(secret, totpStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// disableTwoFactor posts the form turning 2FA off as the user
+func disableTwoFactor(h *Handler, userID int, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/two-factor/disable", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(context.WithValue(req.Context(), UserKey, ContextUser{LoggedIn: true, UserID: userID, Username: "ada_l"}))
+	rec := httptest.NewRecorder()
+	h.DisableTwoFactorHandler(rec, req)
+	return rec
+}
+
+func TestDisableTwoFactorWithoutPassword(t *testing.T) {
+	h, _ := newTestHandler(t)
+	userID, secret := enableTwoFactor(t, h, "")
+	code := currentCode(t, secret)
+
+	if rec := disableTwoFactor(h, userID, url.Values{"password": {""}}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("empty password: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	// the failed attempt throttles the next one
+	if err := h.Limiter.Reset(twoFactorKey(userID)); err != nil {
+		t.Fatal(err)
+	}
+	if rec := disableTwoFactor(h, userID, url.Values{"code": {code}}); rec.Code != http.StatusSeeOther {
+		t.Fatalf("current code: status %d, body %s", rec.Code, rec.Body)
+	}
+	if _, err := h.TwoFactor.Get(userID); err == nil {
+		t.Error("2FA still enabled after disabling it")
+	}
+}
+
+func TestDisableTwoFactorCodeWorksOnce(t *testing.T) {
+	h, _ := newTestHandler(t)
+	userID, secret := enableTwoFactor(t, h, "")
+	code := currentCode(t, secret)
+
+	// a code used to log in cannot confirm a change as well
+	step, _ := matchTOTP(secret, code, time.Now())
+	if ok, err := h.TwoFactor.UseStep(userID, step); err != nil || !ok {
+		t.Fatalf("use step: %v %v", ok, err)
+	}
+	if rec := disableTwoFactor(h, userID, url.Values{"code": {code}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("used code: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDisableTwoFactorNeedsPassword(t *testing.T) {
+	h, _ := newTestHandler(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, secret := enableTwoFactor(t, h, string(hash))
+
+	// users with a password are not let in by a code alone
+	code := currentCode(t, secret)
+	if rec := disableTwoFactor(h, userID, url.Values{"code": {code}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("code without password: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	// the failed attempt throttles the next one
+	if err := h.Limiter.Reset(twoFactorKey(userID)); err != nil {
+		t.Fatal(err)
+	}
+	if rec := disableTwoFactor(h, userID, url.Values{"password": {"correct horse"}}); rec.Code != http.StatusSeeOther {
+		t.Errorf("password: status %d, body %s", rec.Code, rec.Body)
+	}
+}
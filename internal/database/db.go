@@ -4,14 +4,14 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 var DB *sql.DB
 
-func InitDatabase(filepath string) error {
+// OpenDatabase connects to the database without touching its schema
+func OpenDatabase(filepath string) error {
 	var err error
 	dsn := fmt.Sprintf("file:%s?_foreign_keys=ON&_journal_mode=WAL", filepath)
 	DB, err = sql.Open("sqlite3", dsn)
@@ -23,108 +23,29 @@ func InitDatabase(filepath string) error {
 	if err = DB.Ping(); err != nil {
 		return fmt.Errorf("database unreachable: %v", err)
 	}
+	return nil
+}
 
-	// Define all tables
-	queries := []string{
-		// Users table
-		`CREATE TABLE IF NOT EXISTS users (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			username TEXT UNIQUE NOT NULL,
-			email TEXT UNIQUE NOT NULL,
-			password TEXT NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)`,
-
-		// Categories table (created before posts for foreign key reference)
-		`CREATE TABLE IF NOT EXISTS categories (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT UNIQUE NOT NULL,
-			description TEXT
-		)`,
-
-		// Posts table
-		`CREATE TABLE IF NOT EXISTS posts (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id INTEGER NOT NULL,
-			title TEXT NOT NULL,
-			content TEXT NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-		)`,
-
-		// Post Categories junction table
-		`CREATE TABLE IF NOT EXISTS post_categories (
-			post_id INTEGER NOT NULL,
-			category_id INTEGER NOT NULL,
-			PRIMARY KEY (post_id, category_id),
-			FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
-			FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE
-		)`,
-
-		// Comments table
-		`CREATE TABLE IF NOT EXISTS comments (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			post_id INTEGER NOT NULL,
-			user_id INTEGER NOT NULL,
-			content TEXT NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-		)`,
-
-		// Sessions table
-		`CREATE TABLE IF NOT EXISTS sessions (
-			id TEXT PRIMARY KEY,
-			user_id INTEGER NOT NULL UNIQUE,
-			expires_at TIMESTAMP NOT NULL,
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-		)`,
-
-		// Post Likes table
-		`CREATE TABLE IF NOT EXISTS post_reactions (
-			post_id INTEGER NOT NULL,
-			user_id INTEGER NOT NULL,
-			liked   INTEGER NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			PRIMARY KEY (post_id, user_id),
-			FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-		)`,
-
-		`CREATE TABLE IF NOT EXISTS comment_reactions (
-			comment_id INT NOT NULL,
-			user_id INT NOT NULL,
-			liked BOOLEAN NOT NULL,
-			PRIMARY KEY(comment_id, user_id),
-			FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE,
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-		)`,
-
-		// Indexes
-		`CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires_at)`,
+// InitDatabase opens the database and migrates it to the newest schema
+func InitDatabase(filepath string) error {
+	if err := OpenDatabase(filepath); err != nil {
+		return err
+	}
 
-		// Initial categories (using separate inserts for clarity)
-		`INSERT OR IGNORE INTO categories (name, description) VALUES ('General', 'General discussions')`,
-		`INSERT OR IGNORE INTO categories (name, description) VALUES ('Technology', 'Technology related topics')`,
-		`INSERT OR IGNORE INTO categories (name, description) VALUES ('Science', 'Scientific discussions')`,
-		`INSERT OR IGNORE INTO categories (name, description) VALUES ('Entertainment', 'Entertainment and media')`,
-		`INSERT OR IGNORE INTO categories (name, description) VALUES ('Sports', 'Sports related discussions')`,
+	// Bring the schema to the newest embedded migration
+	latest, err := LatestVersion()
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %v", err)
+	}
+	if err := Migrate(latest); err != nil {
+		return fmt.Errorf("failed to migrate database: %v", err)
 	}
 
-	// Execute all queries
-	for _, query := range queries {
-		if _, err := DB.Exec(query); err != nil {
-			return fmt.Errorf("failed to execute query: %v", err)
-		}
+	// the search index depends on FTS5 being compiled in, so it is not a migration
+	if err := initSearchIndex(); err != nil {
+		return err
 	}
 
 	log.Println("Database initialized successfully")
 	return nil
 }
-
-func CleanSessions() {
-	if _, err := DB.Exec("DELETE FROM sessions WHERE expires_at < ?", time.Now()); err != nil {
-		log.Printf("Failed to clean expired sessions: %v", err)
-	}
-}
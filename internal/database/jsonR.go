@@ -0,0 +1,50 @@
+package db
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// APIPrefix is where the JSON API is served, its errors are JSON too
+const APIPrefix = "/api/"
+
+// APIError is the body of every error of the JSON API
+type APIError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+//RenderJSON writes v as the JSON body of the response with the status code
+func RenderJSON(w http.ResponseWriter, code int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("JSON encoding: %v", err)
+		code, body = http.StatusInternalServerError, []byte(`{"error":{"code":500,"message":"Internal server error"}}`)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	w.Write(append(body, '\n'))
+}
+
+//HandleJSONError is HandleError for the JSON API
+func HandleJSONError(w http.ResponseWriter, code int, message string) {
+	var apiErr APIError
+	apiErr.Error.Code = code
+	apiErr.Error.Message = message
+	RenderJSON(w, code, apiErr)
+}
+
+//HandleRequestError answers with a JSON error under the API and with the error page elsewhere,
+//for the middlewares that run in front of both
+func HandleRequestError(w http.ResponseWriter, r *http.Request, code int, message string) {
+	if strings.HasPrefix(r.URL.Path, APIPrefix) {
+		HandleJSONError(w, code, message)
+		return
+	}
+	HandleError(w, code, message)
+}
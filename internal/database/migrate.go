@@ -0,0 +1,270 @@
+package db
+
+import (
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrations are embedded as NNNN_name.up.sql with an optional NNNN_name.down.sql
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// loadMigrations reads the embedded migrations ordered by version
+func loadMigrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("unexpected migration file %s", name)
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		versionStr, migrationName, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration file %s must be named NNNN_name.%s.sql", name, direction)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid version in migration file %s", name)
+		}
+
+		content, err := migrationFiles.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: migrationName}
+			byVersion[version] = m
+		}
+		if m.Name != migrationName {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, migrationName)
+		}
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// LatestVersion is the version of the newest embedded migration
+func LatestVersion() (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+func ensureMigrationsTable() error {
+	_, err := DB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	return err
+}
+
+// appliedMigrations returns the applied versions with the time they were applied
+func appliedMigrations() (map[int]time.Time, error) {
+	if err := ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	rows, err := DB.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// MigrationsStatus lists every embedded migration and when it was applied
+func MigrationsStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		s := MigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := applied[m.Version]; ok {
+			s.AppliedAt = &at
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+// Migrate applies the up migrations up to target and reverts the applied ones
+// above it, newest first. Each migration runs in its own transaction.
+func Migrate(target int) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	known := target == 0
+	for _, m := range migrations {
+		if m.Version == target {
+			known = true
+		}
+	}
+	if !known {
+		return fmt.Errorf("unknown migration version %d", target)
+	}
+
+	applied, err := appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, done := applied[m.Version]; done || m.Version > target {
+			continue
+		}
+		if err := runMigration(m, m.Up, true); err != nil {
+			return fmt.Errorf("migration %d_%s up: %v", m.Version, m.Name, err)
+		}
+		log.Printf("Applied migration %d_%s", m.Version, m.Name)
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if _, done := applied[m.Version]; !done || m.Version <= target {
+			continue
+		}
+		if m.Down == "" {
+			return fmt.Errorf("migration %d_%s cannot be reverted", m.Version, m.Name)
+		}
+		if err := runMigration(m, m.Down, false); err != nil {
+			return fmt.Errorf("migration %d_%s down: %v", m.Version, m.Name, err)
+		}
+		log.Printf("Reverted migration %d_%s", m.Version, m.Name)
+	}
+	return nil
+}
+
+func runMigration(m Migration, script string, up bool) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range splitStatements(script) {
+		if _, err := tx.Exec(stmt); err != nil {
+			// databases upgraded before the migrations existed may already have the column
+			if up && strings.Contains(err.Error(), "duplicate column name") {
+				log.Printf("Migration %d_%s: column already exists, skipping: %s", m.Version, m.Name, stmt)
+				continue
+			}
+			return err
+		}
+	}
+
+	if up {
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name)
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// splitStatements splits a script on the semicolons ending a line, keeping
+// trigger bodies (BEGIN ... END;) together and dropping comment lines.
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	inBlock := false
+
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+
+		upper := strings.ToUpper(trimmed)
+		if strings.HasSuffix(upper, "BEGIN") {
+			inBlock = true
+		}
+		if inBlock && upper != "END;" {
+			continue
+		}
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSpace(current.String()))
+			current.Reset()
+			inBlock = false
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
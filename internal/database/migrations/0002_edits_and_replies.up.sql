@@ -0,0 +1,5 @@
+-- Edit timestamps, soft deleted comments and threaded replies
+ALTER TABLE posts ADD COLUMN updated_at TIMESTAMP;
+ALTER TABLE comments ADD COLUMN updated_at TIMESTAMP;
+ALTER TABLE comments ADD COLUMN deleted INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE comments ADD COLUMN parent_comment_id INTEGER REFERENCES comments(id) ON DELETE CASCADE;
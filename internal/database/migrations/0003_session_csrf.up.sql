@@ -0,0 +1,3 @@
+-- Per-session CSRF token, sessions opened before it get a random one
+ALTER TABLE sessions ADD COLUMN csrf_token TEXT NOT NULL DEFAULT '';
+UPDATE sessions SET csrf_token = lower(hex(randomblob(32))) WHERE csrf_token = '';
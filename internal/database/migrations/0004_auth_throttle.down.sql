@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS auth_attempts;
+DROP TABLE IF EXISTS auth_throttles;
@@ -0,0 +1,19 @@
+-- Attempt counters of the login and registration rate limiter, keyed by client IP or account
+CREATE TABLE IF NOT EXISTS auth_throttles (
+	key TEXT PRIMARY KEY,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_attempt_at TIMESTAMP NOT NULL,
+	locked_until TIMESTAMP
+);
+
+-- Audit of failed and refused login and registration attempts
+CREATE TABLE IF NOT EXISTS auth_attempts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL,
+	ip TEXT NOT NULL,
+	email TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_auth_attempts_created_at ON auth_attempts(created_at);
@@ -0,0 +1,18 @@
+-- Back to one session per user, keeping the newest
+CREATE TABLE sessions_old (
+	id TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL UNIQUE,
+	expires_at TIMESTAMP NOT NULL,
+	csrf_token TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO sessions_old (id, user_id, expires_at, csrf_token)
+SELECT id, user_id, expires_at, csrf_token FROM sessions
+WHERE rowid IN (SELECT MAX(rowid) FROM sessions GROUP BY user_id);
+
+DROP TABLE sessions;
+ALTER TABLE sessions_old RENAME TO sessions;
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires_at);
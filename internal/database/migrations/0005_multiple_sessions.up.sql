@@ -0,0 +1,22 @@
+-- Many sessions per user, each with where and when it was used.
+-- SQLite cannot drop the UNIQUE on user_id in place, so the table is rebuilt.
+CREATE TABLE sessions_new (
+	id TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	csrf_token TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	last_seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO sessions_new (id, user_id, csrf_token, expires_at)
+SELECT id, user_id, csrf_token, expires_at FROM sessions WHERE user_id IS NOT NULL AND expires_at IS NOT NULL;
+
+DROP TABLE sessions;
+ALTER TABLE sessions_new RENAME TO sessions;
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires_at);
@@ -0,0 +1,2 @@
+-- Remembered sessions slide by the long lifetime instead of the default one
+ALTER TABLE sessions ADD COLUMN remember INTEGER NOT NULL DEFAULT 0;
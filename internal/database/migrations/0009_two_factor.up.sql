@@ -0,0 +1,30 @@
+-- TOTP secrets, enabled_at stays NULL until the user confirmed a first code.
+-- last_step is the newest time step accepted, so a code cannot be replayed.
+CREATE TABLE IF NOT EXISTS user_totp (
+	user_id INTEGER PRIMARY KEY,
+	secret TEXT NOT NULL,
+	enabled_at TIMESTAMP,
+	last_step INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Single-use recovery codes, only their SHA-256 is stored
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	code_hash TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	used_at TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes(user_id);
+
+-- Logins that passed the password and wait for the second factor,
+-- only the SHA-256 of their cookie is stored
+CREATE TABLE IF NOT EXISTS pending_logins (
+	token_hash TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	remember BOOLEAN NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,13 @@
+-- Accounts at OAuth/OpenID Connect providers that log in as a forum user,
+-- subject is the stable id of the user at the provider
+CREATE TABLE IF NOT EXISTS user_identities (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	provider TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	email TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (provider, subject),
+	UNIQUE (user_id, provider),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,4 @@
+-- Roles, a moderator can change any post or comment and an admin can also
+-- manage categories and users. Run "forum role <email> admin" to pick the admin
+-- of a database that already has users.
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator', 'admin'));
@@ -0,0 +1,6 @@
+-- Categories managed by admins: subcategories, their order among siblings and
+-- archived categories, which keep their posts but take no new ones
+ALTER TABLE categories ADD COLUMN parent_id INTEGER REFERENCES categories(id) ON DELETE SET NULL;
+ALTER TABLE categories ADD COLUMN position INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE categories ADD COLUMN archived_at TIMESTAMP;
+UPDATE categories SET position = id;
@@ -0,0 +1,5 @@
+DROP TABLE user_warnings;
+DROP TABLE moderation_log;
+DROP TABLE reports;
+ALTER TABLE comments DROP COLUMN hidden_at;
+ALTER TABLE posts DROP COLUMN hidden_at;
@@ -0,0 +1,46 @@
+-- Reports of posts and comments, the moderation log and the warnings moderators
+-- give. Hidden posts and comments stay visible to their author and to moderators.
+ALTER TABLE posts ADD COLUMN hidden_at TIMESTAMP;
+ALTER TABLE comments ADD COLUMN hidden_at TIMESTAMP;
+
+CREATE TABLE reports (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    reporter_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    post_id INTEGER REFERENCES posts(id) ON DELETE CASCADE,
+    comment_id INTEGER REFERENCES comments(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL CHECK (reason IN ('spam', 'harassment', 'hate', 'off_topic', 'illegal', 'other')),
+    details TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMP,
+    resolved_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    resolution TEXT,
+    CHECK ((post_id IS NULL) <> (comment_id IS NULL))
+);
+
+-- one open report per user and content
+CREATE UNIQUE INDEX idx_reports_open_post ON reports(post_id, reporter_id)
+    WHERE resolved_at IS NULL AND post_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_reports_open_comment ON reports(comment_id, reporter_id)
+    WHERE resolved_at IS NULL AND comment_id IS NOT NULL;
+
+-- the log keeps the ids of what it refers to after it is deleted
+CREATE TABLE moderation_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    moderator_id INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    report_id INTEGER,
+    post_id INTEGER,
+    comment_id INTEGER,
+    target_user_id INTEGER,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE user_warnings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    moderator_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_user_warnings_user ON user_warnings(user_id);
@@ -0,0 +1,15 @@
+-- Sanctions moderators put on users. Suspensions end at expires_at, bans and
+-- shadowbans last until they are lifted. A shadowbanned user keeps posting but
+-- only they see what they write.
+CREATE TABLE user_bans (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    moderator_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('suspension', 'ban', 'shadowban')),
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP,
+    lifted_at TIMESTAMP,
+    CHECK ((kind = 'suspension') = (expires_at IS NOT NULL))
+);
+CREATE INDEX idx_user_bans_user ON user_bans(user_id);
@@ -0,0 +1,16 @@
+-- Notifications of a user about what others did with their posts and comments,
+-- every one of them is about a post. An unread notification is not repeated, so
+-- liking a post over and over only notifies its author once.
+CREATE TABLE notifications (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    actor_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL CHECK (kind IN ('comment', 'reply', 'like', 'dislike', 'mention')),
+    post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    comment_id INTEGER REFERENCES comments(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    read_at TIMESTAMP
+);
+CREATE INDEX idx_notifications_user ON notifications(user_id, read_at);
+CREATE UNIQUE INDEX idx_notifications_unread ON notifications(user_id, actor_id, kind, post_id, COALESCE(comment_id, 0))
+    WHERE read_at IS NULL;
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// SearchEnabled reports whether the full-text index is available, it needs
+// the sqlite driver built with FTS5 support (go build -tags sqlite_fts5).
+var SearchEnabled bool
+
+var searchFallback = flag.Bool("search-fallback", false, "Start without FTS5 and search with plain text matching instead of failing")
+
+// search_index holds one row per post and per live comment
+const searchTable = `CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(
+	kind UNINDEXED,
+	post_id UNINDEXED,
+	comment_id UNINDEXED,
+	title,
+	body,
+	tokenize = 'porter unicode61'
+)`
+
+// triggers keeping search_index in sync with posts and comments
+var searchTriggers = map[string]string{
+	"search_posts_insert": `AFTER INSERT ON posts BEGIN
+		INSERT INTO search_index (kind, post_id, comment_id, title, body)
+		VALUES ('post', new.id, NULL, new.title, new.content);
+	END`,
+	"search_posts_update": `AFTER UPDATE OF title, content ON posts BEGIN
+		DELETE FROM search_index WHERE kind = 'post' AND post_id = old.id;
+		INSERT INTO search_index (kind, post_id, comment_id, title, body)
+		VALUES ('post', new.id, NULL, new.title, new.content);
+	END`,
+	"search_posts_delete": `AFTER DELETE ON posts BEGIN
+		DELETE FROM search_index WHERE post_id = old.id;
+	END`,
+	"search_comments_insert": `AFTER INSERT ON comments BEGIN
+		INSERT INTO search_index (kind, post_id, comment_id, title, body)
+		VALUES ('comment', new.post_id, new.id, '', new.content);
+	END`,
+	"search_comments_update": `AFTER UPDATE OF content, deleted ON comments BEGIN
+		DELETE FROM search_index WHERE kind = 'comment' AND comment_id = old.id;
+		INSERT INTO search_index (kind, post_id, comment_id, title, body)
+		SELECT 'comment', new.post_id, new.id, '', new.content WHERE new.deleted = 0;
+	END`,
+	"search_comments_delete": `AFTER DELETE ON comments BEGIN
+		DELETE FROM search_index WHERE kind = 'comment' AND comment_id = old.id;
+	END`,
+}
+
+// initSearchIndex creates the full-text index and its triggers. The index is
+// rebuilt whenever triggers were missing, as writes made meanwhile are not in it.
+func initSearchIndex() error {
+	if _, err := DB.Exec(searchTable); err != nil {
+		if isMissingFTS5(err) {
+			return withoutFTS5()
+		}
+		return fmt.Errorf("failed to create search index: %v", err)
+	}
+	// an index created by an FTS5 build can exist while the module is missing
+	if _, err := DB.Exec("SELECT COUNT(*) FROM search_index"); err != nil {
+		if isMissingFTS5(err) {
+			return withoutFTS5()
+		}
+		return fmt.Errorf("failed to read search index: %v", err)
+	}
+
+	var existing int
+	err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'search_%'").Scan(&existing)
+	if err != nil {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for name, body := range searchTriggers {
+		if _, err := tx.Exec("CREATE TRIGGER IF NOT EXISTS " + name + " " + body); err != nil {
+			return fmt.Errorf("failed to create search trigger %s: %v", name, err)
+		}
+	}
+
+	if existing < len(searchTriggers) {
+		rebuild := []string{
+			`DELETE FROM search_index`,
+			`INSERT INTO search_index (kind, post_id, comment_id, title, body)
+			SELECT 'post', id, NULL, title, content FROM posts`,
+			`INSERT INTO search_index (kind, post_id, comment_id, title, body)
+			SELECT 'comment', post_id, id, '', content FROM comments WHERE deleted = 0`,
+		}
+		for _, query := range rebuild {
+			if _, err := tx.Exec(query); err != nil {
+				return fmt.Errorf("failed to fill search index: %v", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	SearchEnabled = true
+	return nil
+}
+
+// withoutFTS5 refuses to start without the full-text index, unless the plain
+// text fallback was asked for
+func withoutFTS5() error {
+	if !*searchFallback {
+		return fmt.Errorf("SQLite lacks FTS5: build with -tags sqlite_fts5, or run with -search-fallback to search with plain text matching")
+	}
+	return disableSearch()
+}
+
+// disableSearch drops the sync triggers so writes keep working without FTS5
+func disableSearch() error {
+	log.Println("FTS5 is not available, search falls back to plain text matching")
+	for name := range searchTriggers {
+		if _, err := DB.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+			return fmt.Errorf("failed to drop search trigger %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func isMissingFTS5(err error) bool {
+	return strings.Contains(err.Error(), "no such module: fts5")
+}
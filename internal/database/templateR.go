@@ -65,11 +65,28 @@ func in(slice interface{}, value interface{}) bool {
 	return false
 }
 
+//custom 'dict' function to pass several values to a nested template
+func dict(values ...interface{}) (map[string]interface{}, error) {
+	if len(values)%2 != 0 {
+		return nil, fmt.Errorf("dict expects key and value pairs")
+	}
+	m := make(map[string]interface{}, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		key, ok := values[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict keys must be strings")
+		}
+		m[key] = values[i+1]
+	}
+	return m, nil
+}
+
 func InitTemplates() error {
 	var err error
 	tmpl := template.New("")
 	tmpl.Funcs(template.FuncMap{
-		"in": in,
+		"in":   in,
+		"dict": dict,
 	})
 	templates, err = tmpl.New("").ParseFiles(
 		"templates/home.html",
@@ -77,6 +94,25 @@ func InitTemplates() error {
 		"templates/register.html",
 		"templates/add_post.html",
 		"templates/add_comment.html",
+		"templates/post.html",
+		"templates/search.html",
+		"templates/comment.html",
+		"templates/account.html",
+		"templates/api_tokens.html",
+		"templates/notifications.html",
+		"templates/messages.html",
+		"templates/conversation.html",
+		"templates/forgot_password.html",
+		"templates/reset_password.html",
+		"templates/verify_email.html",
+		"templates/two_factor.html",
+		"templates/login_two_factor.html",
+		"templates/admin_users.html",
+		"templates/admin_categories.html",
+		"templates/report.html",
+		"templates/moderation.html",
+		"templates/moderation_log.html",
+		"templates/moderation_users.html",
 		"templates/error.html",
 	)
 	if err != nil {
@@ -92,6 +128,21 @@ func InitTemplates() error {
 	return nil
 }
 
+// csrfWriter carries the CSRF token of the session down to RenderTemplate
+type csrfWriter struct {
+	http.ResponseWriter
+	token string
+}
+
+func (w *csrfWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// WithCSRFToken makes the templates rendered on w see token as .CSRFToken
+func WithCSRFToken(w http.ResponseWriter, token string) http.ResponseWriter {
+	return &csrfWriter{ResponseWriter: w, token: token}
+}
+
 func RenderTemplate(w http.ResponseWriter, name string, data interface{}) {
 	var dataMap map[string]interface{}
 	if data == nil {
@@ -109,6 +160,12 @@ func RenderTemplate(w http.ResponseWriter, name string, data interface{}) {
 		dataMap["Title"] = name
 	}
 
+	//every form posting back needs the token of the session
+	dataMap["CSRFToken"] = ""
+	if cw, ok := w.(*csrfWriter); ok {
+		dataMap["CSRFToken"] = cw.token
+	}
+
 	//execute template
 	err := templates.ExecuteTemplate(w, name+".html", dataMap)
 	if err != nil {
@@ -0,0 +1,91 @@
+package events
+
+import "sync"
+
+// Types of the events, they name the server-sent events and the WebSocket messages too
+const (
+	TypePost     = "post"
+	TypeComment  = "comment"
+	TypeReaction = "reaction"
+	TypeMessage  = "message"
+	TypeTyping   = "typing"
+	TypeUnread   = "unread"
+)
+
+// Event is a change on the forum that open pages can show without a reload.
+// CategoryIDs are the categories of the post it is about, for the filters of the
+// subscribers, and Data is what the pages receive as JSON. Private messages leave
+// CategoryIDs empty, they go to the hub of their users.
+type Event struct {
+	Type        string
+	CategoryIDs []int
+	Data        interface{}
+}
+
+// Bus hands the published events to every subscriber. Publishing never waits for
+// a subscriber: one whose buffer is full is dropped, its channel closed, and has to
+// subscribe again, which is what the browsers do on their own with server-sent events.
+type Bus struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	buffer int
+	max    int
+}
+
+// Subscription receives the events on C until it is closed by Close or dropped by
+// the bus or the hub it came from
+type Subscription struct {
+	C     <-chan Event
+	ch    chan Event
+	close func()
+}
+
+// NewBus returns a bus buffering up to buffer events per subscriber, with at most
+// max subscribers at once
+func NewBus(buffer, max int) *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{}), buffer: buffer, max: max}
+}
+
+// Subscribe adds a subscriber, nil when the bus already has as many as it takes
+func (b *Bus) Subscribe() *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.subs) >= b.max {
+		return nil
+	}
+	ch := make(chan Event, b.buffer)
+	sub := &Subscription{C: ch, ch: ch}
+	sub.close = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(sub)
+	}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// Publish sends the event to the subscribers and drops the ones that fell behind
+func (b *Bus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		select {
+		case sub.ch <- e:
+		default:
+			b.remove(sub)
+		}
+	}
+}
+
+// Close ends the subscription, it can be called after it was dropped
+func (s *Subscription) Close() {
+	s.close()
+}
+
+// remove closes the channel of a subscriber still on the bus, with mu held
+func (b *Bus) remove(sub *Subscription) {
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
@@ -0,0 +1,70 @@
+package events
+
+import "sync"
+
+// Hub hands events to the subscribers of one user, the open pages of their private
+// messages. Like the bus it never waits: a subscriber that falls behind is dropped
+// and has to subscribe again.
+type Hub struct {
+	mu      sync.Mutex
+	subs    map[int]map[*Subscription]struct{}
+	count   int
+	buffer  int
+	max     int
+	perUser int
+}
+
+// NewHub returns a hub buffering up to buffer events per subscriber, with at most
+// max subscribers at once and perUser of them for the same user
+func NewHub(buffer, max, perUser int) *Hub {
+	return &Hub{subs: make(map[int]map[*Subscription]struct{}), buffer: buffer, max: max, perUser: perUser}
+}
+
+// Subscribe adds a subscriber for the user, nil when the hub or the user already
+// has as many as it takes
+func (h *Hub) Subscribe(userID int) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count >= h.max || len(h.subs[userID]) >= h.perUser {
+		return nil
+	}
+	ch := make(chan Event, h.buffer)
+	sub := &Subscription{C: ch, ch: ch}
+	sub.close = func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.remove(userID, sub)
+	}
+	if h.subs[userID] == nil {
+		h.subs[userID] = make(map[*Subscription]struct{})
+	}
+	h.subs[userID][sub] = struct{}{}
+	h.count++
+	return sub
+}
+
+// Send hands the event to the subscribers of the user and drops the ones that fell behind
+func (h *Hub) Send(userID int, e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs[userID] {
+		select {
+		case sub.ch <- e:
+		default:
+			h.remove(userID, sub)
+		}
+	}
+}
+
+// remove closes the channel of a subscriber still on the hub, with mu held
+func (h *Hub) remove(userID int, sub *Subscription) {
+	if _, ok := h.subs[userID][sub]; !ok {
+		return
+	}
+	delete(h.subs[userID], sub)
+	if len(h.subs[userID]) == 0 {
+		delete(h.subs, userID)
+	}
+	h.count--
+	close(sub.ch)
+}
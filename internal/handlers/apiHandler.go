@@ -0,0 +1,473 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"forum/internal/auth"
+	"forum/internal/store"
+
+	db "forum/internal/database"
+)
+
+// maxAPIBody bounds the JSON bodies the API reads
+const maxAPIBody = 1 << 20
+
+// apiAuthor is the user who wrote a post or a comment
+type apiAuthor struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
+
+// apiComment is a comment with its replies as the JSON API returns it, the author
+// and content of deleted or hidden comments are left out like on the pages
+type apiComment struct {
+	ID        int          `json:"id"`
+	PostID    int          `json:"post_id"`
+	ParentID  *int         `json:"parent_id"`
+	Author    *apiAuthor   `json:"author,omitempty"`
+	Content   string       `json:"content"`
+	Deleted   bool         `json:"deleted"`
+	Hidden    bool         `json:"hidden"`
+	Likes     int          `json:"likes"`
+	Dislikes  int          `json:"dislikes"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt *time.Time   `json:"updated_at"`
+	Replies   []apiComment `json:"replies"`
+}
+
+// apiPost is a post as the JSON API lists it
+type apiPost struct {
+	ID           int        `json:"id"`
+	Author       apiAuthor  `json:"author"`
+	Title        string     `json:"title"`
+	Content      string     `json:"content"`
+	Categories   []string   `json:"categories"`
+	Likes        int        `json:"likes"`
+	Dislikes     int        `json:"dislikes"`
+	CommentCount int        `json:"comment_count"`
+	Hidden       bool       `json:"hidden"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    *time.Time `json:"updated_at"`
+}
+
+// apiPostDetail is a single post with its comment tree
+type apiPostDetail struct {
+	apiPost
+	Comments []apiComment `json:"comments"`
+}
+
+// apiCategory is a category in tree order, Depth is how many parents it has
+type apiCategory struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	ParentID    *int   `json:"parent_id"`
+	Depth       int    `json:"depth"`
+	Archived    bool   `json:"archived"`
+	PostCount   int    `json:"post_count"`
+}
+
+func newAPIPost(post store.Post) apiPost {
+	return apiPost{
+		ID:           post.ID,
+		Author:       apiAuthor{ID: post.UserID, Username: post.Username},
+		Title:        post.Title,
+		Content:      post.Content,
+		Categories:   post.Categories,
+		Likes:        post.Likes,
+		Dislikes:     post.Dislikes,
+		CommentCount: post.CommentCount,
+		Hidden:       post.Hidden,
+		CreatedAt:    post.CreatedAt,
+		UpdatedAt:    post.UpdatedAt,
+	}
+}
+
+// newAPIComments converts a comment tree as the user sees it
+func newAPIComments(comments []store.Comment, user auth.ContextUser) []apiComment {
+	list := make([]apiComment, len(comments))
+	for i, c := range comments {
+		list[i] = apiComment{
+			ID:        c.ID,
+			PostID:    c.PostID,
+			ParentID:  c.ParentID,
+			Deleted:   c.Deleted,
+			Hidden:    c.Hidden,
+			Likes:     c.Likes,
+			Dislikes:  c.Dislikes,
+			CreatedAt: c.CreatedAt,
+			UpdatedAt: c.UpdatedAt,
+			Replies:   newAPIComments(c.Replies, user),
+		}
+		if !c.Deleted && (!c.Hidden || c.UserID == user.UserID || user.CanModerate()) {
+			list[i].Author = &apiAuthor{ID: c.UserID, Username: c.Username}
+			list[i].Content = c.Content
+		}
+	}
+	return list
+}
+
+//apiUser returns the current user, answering 401 when nobody is logged in
+func apiUser(w http.ResponseWriter, r *http.Request) (auth.ContextUser, bool) {
+	userData, _ := r.Context().Value(auth.UserKey).(auth.ContextUser)
+	if !userData.LoggedIn {
+		db.HandleJSONError(w, http.StatusUnauthorized, "Authentication required")
+		return userData, false
+	}
+	return userData, true
+}
+
+//apiWriter returns the current user if they may write posts and comments, like RequireVerified
+func apiWriter(w http.ResponseWriter, r *http.Request) (auth.ContextUser, bool) {
+	userData, ok := apiUser(w, r)
+	if ok && !userData.CanPost() {
+		db.HandleJSONError(w, http.StatusForbidden, "Verify your email address first")
+		return userData, false
+	}
+	return userData, ok
+}
+
+//decodeJSON reads the JSON body of the request into v, answering 400 when it is not valid
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		db.HandleJSONError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+//apiMethod answers 405 unless the request uses one of the methods
+func apiMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	for _, m := range methods {
+		w.Header().Add("Allow", m)
+	}
+	db.HandleJSONError(w, http.StatusMethodNotAllowed, "Invalid method")
+	return false
+}
+
+//pathID reads the {id} of the route
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		db.HandleJSONError(w, http.StatusBadRequest, "Invalid id")
+		return 0, false
+	}
+	return id, true
+}
+
+//visiblePost loads a post the user can see, answering 404 otherwise like PostHandler
+func (h *Handler) visiblePost(w http.ResponseWriter, postID int, user auth.ContextUser) (*store.Post, bool) {
+	post, status, msg := h.getVisiblePost(postID, user)
+	if status != 0 {
+		db.HandleJSONError(w, status, msg)
+		return nil, false
+	}
+	return post, true
+}
+
+//APINotFoundHandler answers the unknown API routes
+func (h *Handler) APINotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	db.HandleJSONError(w, http.StatusNotFound, "Not found")
+}
+
+//APICategoriesHandler lists the categories in tree order
+func (h *Handler) APICategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	if !apiMethod(w, r, http.MethodGet) {
+		return
+	}
+	categories, err := h.Categories.List()
+	if err != nil {
+		db.HandleJSONError(w, http.StatusInternalServerError, "Error loading categories")
+		return
+	}
+
+	list := make([]apiCategory, len(categories))
+	for i, cat := range categories {
+		list[i] = apiCategory{
+			ID:          cat.ID,
+			Name:        cat.Name,
+			Description: cat.Description,
+			Depth:       cat.Depth,
+			Archived:    cat.Archived,
+			PostCount:   cat.PostCount,
+		}
+		if cat.ParentID != 0 {
+			parentID := cat.ParentID
+			list[i].ParentID = &parentID
+		}
+	}
+	db.RenderJSON(w, http.StatusOK, map[string]interface{}{"categories": list})
+}
+
+//APIPostsHandler lists the posts with the filters, sort and pages of the home page on GET, and
+//creates a post on POST
+func (h *Handler) APIPostsHandler(w http.ResponseWriter, r *http.Request) {
+	if !apiMethod(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	if r.Method == http.MethodPost {
+		h.apiCreatePost(w, r)
+		return
+	}
+
+	userData, _ := r.Context().Value(auth.UserKey).(auth.ContextUser)
+	q := r.URL.Query()
+
+	// one extra post only tells that there is a next page.
+	page, limit := parsePage(q)
+	filter := buildPostFilter(q, userData)
+	filter.Limit, filter.Offset = limit+1, (page-1)*limit
+
+	posts, err := h.Posts.List(filter)
+	if err != nil {
+		db.HandleJSONError(w, http.StatusInternalServerError, "Error loading posts")
+		return
+	}
+	hasNext := len(posts) > limit
+	if hasNext {
+		posts = posts[:limit]
+	}
+
+	list := make([]apiPost, len(posts))
+	for i, post := range posts {
+		list[i] = newAPIPost(post)
+	}
+	db.RenderJSON(w, http.StatusOK, map[string]interface{}{
+		"posts":    list,
+		"page":     page,
+		"limit":    limit,
+		"has_next": hasNext,
+	})
+}
+
+func (h *Handler) apiCreatePost(w http.ResponseWriter, r *http.Request) {
+	userData, ok := apiWriter(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Title      string `json:"title"`
+		Content    string `json:"content"`
+		Categories []int  `json:"categories"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+
+	categories, err := h.Categories.List()
+	if err != nil {
+		db.HandleJSONError(w, http.StatusInternalServerError, "Error loading categories")
+		return
+	}
+	if msg := validatePost(body.Title, body.Content, body.Categories, selectableCategories(categories, nil)); msg != "" {
+		db.HandleJSONError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	postID, err := h.Posts.Create(userData.UserID, body.Title, body.Content, body.Categories)
+	if err != nil {
+		db.HandleJSONError(w, http.StatusInternalServerError, "Failed to create post")
+		return
+	}
+	h.notifyPost(userData.UserID, postID, body.Content)
+	h.publishPost(postID)
+	post, err := h.Posts.Get(postID)
+	if err != nil {
+		db.HandleJSONError(w, http.StatusInternalServerError, "Error loading post")
+		return
+	}
+	w.Header().Set("Location", "/api/v1/posts/"+strconv.Itoa(postID))
+	db.RenderJSON(w, http.StatusCreated, newAPIPost(*post))
+}
+
+//APIPostHandler returns a post with its comment tree
+func (h *Handler) APIPostHandler(w http.ResponseWriter, r *http.Request) {
+	if !apiMethod(w, r, http.MethodGet) {
+		return
+	}
+	userData, _ := r.Context().Value(auth.UserKey).(auth.ContextUser)
+	postID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	post, ok := h.visiblePost(w, postID, userData)
+	if !ok {
+		return
+	}
+
+	posts := []store.Post{*post}
+	if err := h.loadComments(posts, userData.UserID); err != nil {
+		db.HandleJSONError(w, http.StatusInternalServerError, "Error loading comments")
+		return
+	}
+	db.RenderJSON(w, http.StatusOK, apiPostDetail{
+		apiPost:  newAPIPost(posts[0]),
+		Comments: newAPIComments(posts[0].Comments, userData),
+	})
+}
+
+//APICommentsHandler adds a comment to a post, or a reply when parent_id is set
+func (h *Handler) APICommentsHandler(w http.ResponseWriter, r *http.Request) {
+	if !apiMethod(w, r, http.MethodPost) {
+		return
+	}
+	userData, ok := apiWriter(w, r)
+	if !ok {
+		return
+	}
+	postID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Content  string `json:"content"`
+		ParentID *int   `json:"parent_id"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+	if _, ok := h.visiblePost(w, postID, userData); !ok {
+		return
+	}
+
+	//replies go to a comment of the same post
+	if body.ParentID != nil {
+		parent, status, msg := h.getComment(*body.ParentID)
+		if status == 0 && parent.PostID != postID {
+			status, msg = http.StatusNotFound, "Comment not found"
+		}
+		if status != 0 {
+			db.HandleJSONError(w, status, msg)
+			return
+		}
+	}
+	if msg := validateComment(body.Content); msg != "" {
+		db.HandleJSONError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	commentID, err := h.Comments.Create(postID, userData.UserID, body.Content, body.ParentID)
+	if err != nil {
+		db.HandleJSONError(w, http.StatusInternalServerError, "Failed to add comment")
+		return
+	}
+	h.notifyComment(userData.UserID, postID, commentID, body.ParentID, body.Content)
+	h.publishComment(postID, commentID, userData.UserID)
+	comment, err := h.Comments.Get(commentID)
+	if err != nil {
+		db.HandleJSONError(w, http.StatusInternalServerError, "Error loading comment")
+		return
+	}
+	w.Header().Set("Location", "/api/v1/posts/"+strconv.Itoa(postID))
+	db.RenderJSON(w, http.StatusCreated, newAPIComments([]store.Comment{*comment}, userData)[0])
+}
+
+// apiReaction is the body of the reaction routes, posting the current reaction
+// again takes it back like the buttons do
+type apiReaction struct {
+	Reaction string `json:"reaction"` // like or dislike
+}
+
+//readReaction reads the reaction body, answering 400 unless it is a like or a dislike
+func readReaction(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	var body apiReaction
+	if !decodeJSON(w, r, &body) {
+		return false, false
+	}
+	if body.Reaction != "like" && body.Reaction != "dislike" {
+		db.HandleJSONError(w, http.StatusBadRequest, `reaction must be "like" or "dislike"`)
+		return false, false
+	}
+	return body.Reaction == "like", true
+}
+
+//APIPostReactionHandler toggles the like or dislike of the user on a post and returns the post
+func (h *Handler) APIPostReactionHandler(w http.ResponseWriter, r *http.Request) {
+	if !apiMethod(w, r, http.MethodPost) {
+		return
+	}
+	userData, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+	postID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	liked, ok := readReaction(w, r)
+	if !ok {
+		return
+	}
+	post, ok := h.visiblePost(w, postID, userData)
+	if !ok {
+		return
+	}
+
+	added, err := h.Reactions.TogglePost(postID, userData.UserID, liked)
+	if err != nil {
+		db.HandleJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if added {
+		h.notifyReaction(userData.UserID, post.UserID, postID, 0, liked)
+	}
+	h.publishReaction(postID, 0)
+	post, ok = h.visiblePost(w, postID, userData)
+	if !ok {
+		return
+	}
+	db.RenderJSON(w, http.StatusOK, newAPIPost(*post))
+}
+
+//APICommentReactionHandler toggles the like or dislike of the user on a comment and returns the comment
+func (h *Handler) APICommentReactionHandler(w http.ResponseWriter, r *http.Request) {
+	if !apiMethod(w, r, http.MethodPost) {
+		return
+	}
+	userData, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+	commentID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	liked, ok := readReaction(w, r)
+	if !ok {
+		return
+	}
+	comment, status, msg := h.getComment(commentID)
+	if status != 0 {
+		db.HandleJSONError(w, status, msg)
+		return
+	}
+	if _, ok := h.visiblePost(w, comment.PostID, userData); !ok {
+		return
+	}
+
+	added, err := h.Reactions.ToggleComment(commentID, userData.UserID, liked)
+	if err != nil {
+		db.HandleJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if added {
+		h.notifyReaction(userData.UserID, comment.UserID, comment.PostID, commentID, liked)
+	}
+	h.publishReaction(comment.PostID, commentID)
+	comment, err = h.Comments.Get(commentID)
+	if err != nil {
+		db.HandleJSONError(w, http.StatusInternalServerError, "Error loading comment")
+		return
+	}
+	db.RenderJSON(w, http.StatusOK, newAPIComments([]store.Comment{*comment}, userData)[0])
+}
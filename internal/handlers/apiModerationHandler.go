@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"forum/internal/auth"
+	"forum/internal/store"
+
+	db "forum/internal/database"
+)
+
+// apiReport is an open report as the moderation API lists it, one of PostID and
+// CommentID is set
+type apiReport struct {
+	ID        int       `json:"id"`
+	Reporter  apiAuthor `json:"reporter"`
+	PostID    int       `json:"post_id,omitempty"`
+	CommentID int       `json:"comment_id,omitempty"`
+	Reason    string    `json:"reason"`
+	Details   string    `json:"details"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// apiResolution is the body of a request resolving a report
+type apiResolution struct {
+	Action string `json:"action"`
+	Note   string `json:"note"`
+}
+
+//apiModerator returns the current user if they are a moderator and their token has the moderate scope
+func apiModerator(w http.ResponseWriter, r *http.Request) (auth.ContextUser, bool) {
+	userData, ok := apiUser(w, r)
+	if !ok {
+		return userData, false
+	}
+	if !userData.CanModerate() {
+		db.HandleJSONError(w, http.StatusForbidden, "Only moderators can do this")
+		return userData, false
+	}
+	if !userData.Allows(store.ScopeModerate) {
+		db.HandleJSONError(w, http.StatusForbidden, "This token does not have the moderate scope")
+		return userData, false
+	}
+	return userData, true
+}
+
+//APIReportsHandler lists the open reports, oldest first, for moderators
+func (h *Handler) APIReportsHandler(w http.ResponseWriter, r *http.Request) {
+	if !apiMethod(w, r, http.MethodGet) {
+		return
+	}
+	if _, ok := apiModerator(w, r); !ok {
+		return
+	}
+
+	reports, err := h.Reports.ListOpen()
+	if err != nil {
+		db.HandleJSONError(w, http.StatusInternalServerError, "Error loading reports")
+		return
+	}
+	list := make([]apiReport, len(reports))
+	for i, report := range reports {
+		list[i] = apiReport{
+			ID:        report.ID,
+			Reporter:  apiAuthor{ID: report.ReporterID, Username: report.ReporterName},
+			PostID:    report.PostID,
+			CommentID: report.CommentID,
+			Reason:    report.Reason,
+			Details:   report.Details,
+			CreatedAt: report.CreatedAt,
+		}
+	}
+	db.RenderJSON(w, http.StatusOK, map[string]interface{}{"reports": list})
+}
+
+//APIReportHandler resolves a report with {"action", "note"} like the moderation queue does, closing every
+//open report on the same content
+func (h *Handler) APIReportHandler(w http.ResponseWriter, r *http.Request) {
+	if !apiMethod(w, r, http.MethodPost) {
+		return
+	}
+	userData, ok := apiModerator(w, r)
+	if !ok {
+		return
+	}
+	reportID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var body apiResolution
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+
+	if status, msg := h.resolveReport(userData, reportID, body.Action, body.Note); status != 0 {
+		db.HandleJSONError(w, status, msg)
+		return
+	}
+	// the reports of a deleted post are gone with it
+	report, err := h.Reports.Get(reportID)
+	if errors.Is(err, store.ErrNotFound) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		db.HandleJSONError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	db.RenderJSON(w, http.StatusOK, map[string]interface{}{
+		"id":          report.ID,
+		"resolution":  report.Resolution,
+		"resolved_at": report.ResolvedAt,
+	})
+}
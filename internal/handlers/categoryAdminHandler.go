@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"forum/internal/auth"
+	"forum/internal/store"
+
+	db "forum/internal/database"
+)
+
+//AdminCategoriesHandler lists the categories as a tree with the forms to change them, for admins
+func (h *Handler) AdminCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	h.renderAdminCategories(w, r, http.StatusOK, "")
+}
+
+//renderAdminCategories renders the category admin page, with an error above the forms
+func (h *Handler) renderAdminCategories(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
+
+	categories, err := h.Categories.List()
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Error loading categories")
+		return
+	}
+
+	w.WriteHeader(status)
+	db.RenderTemplate(w, "admin_categories", map[string]interface{}{
+		"Title":      "Categories",
+		"LoggedIn":   userData.LoggedIn,
+		"Username":   userData.Username,
+		"Categories": categories,
+		"Error":      msg,
+	})
+}
+
+//validateCategory checks the category form, returning the message to show when it is invalid
+func (h *Handler) validateCategory(id int, name, description string, parentID int) (string, error) {
+	if name == "" || len(name) > 30 {
+		return "Category names must be between 1 and 30 characters.", nil
+	}
+	if len(description) > 200 {
+		return "Descriptions must be at most 200 characters.", nil
+	}
+	if parentID == 0 {
+		return "", nil
+	}
+	return h.checkParent(id, parentID)
+}
+
+//checkParent returns the message to show unless parentID exists and, for an existing category,
+//is neither the category nor one below it
+func (h *Handler) checkParent(id, parentID int) (string, error) {
+	categories, err := h.Categories.List()
+	if err != nil {
+		return "", err
+	}
+	parents := make(map[int]int, len(categories))
+	for _, cat := range categories {
+		parents[cat.ID] = cat.ParentID
+	}
+	if _, ok := parents[parentID]; !ok {
+		return "The parent category does not exist.", nil
+	}
+	for p := parentID; p != 0; p = parents[p] {
+		if p == id {
+			return "A category cannot be placed under itself or its subcategories.", nil
+		}
+	}
+	return "", nil
+}
+
+//categoryForm reads the fields shared by the create and update forms
+func categoryForm(r *http.Request) (name, description string, parentID int) {
+	name = strings.TrimSpace(r.FormValue("name"))
+	description = strings.TrimSpace(r.FormValue("description"))
+	parentID, _ = strconv.Atoi(r.FormValue("parent_id"))
+	return name, description, parentID
+}
+
+//CreateCategoryHandler adds a category, at the top level or under a parent
+func (h *Handler) CreateCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	name, description, parentID := categoryForm(r)
+
+	msg, err := h.validateCategory(0, name, description, parentID)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if msg != "" {
+		h.renderAdminCategories(w, r, http.StatusBadRequest, msg)
+		return
+	}
+
+	_, err = h.Categories.Create(name, description, parentID)
+	if errors.Is(err, store.ErrDuplicate) {
+		h.renderAdminCategories(w, r, http.StatusBadRequest, "There is already a category named "+name+".")
+		return
+	}
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Failed to create category")
+		return
+	}
+	http.Redirect(w, r, "/admin/categories", http.StatusSeeOther)
+}
+
+//UpdateCategoryHandler renames, describes or moves a category under another parent
+func (h *Handler) UpdateCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	id, err := strconv.Atoi(r.FormValue("category_id"))
+	if err != nil {
+		db.HandleError(w, http.StatusBadRequest, "Invalid category ID")
+		return
+	}
+	name, description, parentID := categoryForm(r)
+
+	msg, err := h.validateCategory(id, name, description, parentID)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if msg != "" {
+		h.renderAdminCategories(w, r, http.StatusBadRequest, msg)
+		return
+	}
+
+	err = h.Categories.Update(id, name, description, parentID)
+	switch {
+	case errors.Is(err, store.ErrDuplicate):
+		h.renderAdminCategories(w, r, http.StatusBadRequest, "There is already a category named "+name+".")
+	case errors.Is(err, store.ErrNotFound):
+		db.HandleError(w, http.StatusNotFound, "Category not found")
+	case err != nil:
+		db.HandleError(w, http.StatusInternalServerError, "Failed to update category")
+	default:
+		http.Redirect(w, r, "/admin/categories", http.StatusSeeOther)
+	}
+}
+
+//MoveCategoryHandler moves a category one place up or down among its siblings
+func (h *Handler) MoveCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	id, err := strconv.Atoi(r.FormValue("category_id"))
+	if err != nil {
+		db.HandleError(w, http.StatusBadRequest, "Invalid category ID")
+		return
+	}
+
+	err = h.Categories.Move(id, r.FormValue("direction") == "up")
+	if errors.Is(err, store.ErrNotFound) {
+		db.HandleError(w, http.StatusNotFound, "Category not found")
+		return
+	}
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Failed to move category")
+		return
+	}
+	http.Redirect(w, r, "/admin/categories", http.StatusSeeOther)
+}
+
+//ArchiveCategoryHandler archives a category so it takes no new posts, or restores it
+func (h *Handler) ArchiveCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	id, err := strconv.Atoi(r.FormValue("category_id"))
+	if err != nil {
+		db.HandleError(w, http.StatusBadRequest, "Invalid category ID")
+		return
+	}
+
+	err = h.Categories.SetArchived(id, r.FormValue("archived") == "1")
+	if errors.Is(err, store.ErrNotFound) {
+		db.HandleError(w, http.StatusNotFound, "Category not found")
+		return
+	}
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Failed to archive category")
+		return
+	}
+	http.Redirect(w, r, "/admin/categories", http.StatusSeeOther)
+}
+
+//MergeCategoryHandler moves the posts and subcategories of a category into another one and
+//deletes it
+func (h *Handler) MergeCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	fromID, err := strconv.Atoi(r.FormValue("category_id"))
+	if err != nil {
+		db.HandleError(w, http.StatusBadRequest, "Invalid category ID")
+		return
+	}
+	intoID, err := strconv.Atoi(r.FormValue("into_id"))
+	if err != nil {
+		db.HandleError(w, http.StatusBadRequest, "Invalid category ID")
+		return
+	}
+
+	// the subcategories of from move under into, which cannot be one of them
+	msg, err := h.checkParent(fromID, intoID)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if msg != "" {
+		h.renderAdminCategories(w, r, http.StatusBadRequest, "Pick another category to merge into.")
+		return
+	}
+
+	err = h.Categories.Merge(fromID, intoID)
+	if errors.Is(err, store.ErrNotFound) {
+		db.HandleError(w, http.StatusNotFound, "Category not found")
+		return
+	}
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Failed to merge categories")
+		return
+	}
+	http.Redirect(w, r, "/admin/categories", http.StatusSeeOther)
+}
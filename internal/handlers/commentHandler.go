@@ -1,17 +1,19 @@
 package handlers
 
 import (
-	"database/sql"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"forum/internal/auth"
+	"forum/internal/store"
+
 	db "forum/internal/database"
 )
 
 //commentHandler handles displaying the comment form and processing new comments.
-func CommentHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) CommentHandler(w http.ResponseWriter, r *http.Request) {
 	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
 
 	if r.Method == http.MethodGet {
@@ -20,13 +22,39 @@ func CommentHandler(w http.ResponseWriter, r *http.Request) {
 			db.HandleError(w, http.StatusBadRequest, "Invalid post id")
 			return
 		}
+		if _, status, msg := h.getVisiblePost(postID, userData); status != 0 {
+			db.HandleError(w, status, msg)
+			return
+		}
 
-		db.RenderTemplate(w, "add_comment", map[string]interface{}{
+		data := map[string]interface{}{
 			"Title":    "Add Comment",
 			"PostID":   postID,
 			"LoggedIn": userData.LoggedIn,
 			"Username": userData.Username,
-		})
+		}
+
+		//replying to a comment of the same post
+		if parentStr := r.URL.Query().Get("parent"); parentStr != "" {
+			parentID, err := strconv.Atoi(parentStr)
+			if err != nil {
+				db.HandleError(w, http.StatusBadRequest, "Invalid comment id")
+				return
+			}
+			parent, status, msg := h.getComment(parentID)
+			if status == 0 && parent.PostID != postID {
+				status, msg = http.StatusNotFound, "Comment not found"
+			}
+			if status != 0 {
+				db.HandleError(w, status, msg)
+				return
+			}
+			data["Title"] = "Reply"
+			data["ParentID"] = parentID
+			data["ReplyTo"] = parent.Username
+		}
+
+		db.RenderTemplate(w, "add_comment", data)
 		return
 	}
 	if r.Method == http.MethodPost {
@@ -36,181 +64,236 @@ func CommentHandler(w http.ResponseWriter, r *http.Request) {
 			db.HandleError(w, http.StatusBadRequest, "Invalid post id")
 			return
 		}
+		//comments go on posts the user can see, a missing one would fail on the foreign key
+		if _, status, msg := h.getVisiblePost(postID, userData); status != 0 {
+			db.HandleError(w, status, msg)
+			return
+		}
+
+		//parent_comment_id stays NULL for top level comments
+		var parentID *int
+		if parentStr := r.FormValue("parent_comment_id"); parentStr != "" {
+			id, err := strconv.Atoi(parentStr)
+			if err != nil {
+				db.HandleError(w, http.StatusBadRequest, "Invalid comment id")
+				return
+			}
+			parent, status, msg := h.getComment(id)
+			if status == 0 && parent.PostID != postID {
+				status, msg = http.StatusNotFound, "Comment not found"
+			}
+			if status != 0 {
+				db.HandleError(w, status, msg)
+				return
+			}
+			parentID = &id
+		}
 
 		content := r.FormValue("content")
-		if strings.TrimSpace(content) == "" {
+		if msg := validateComment(content); msg != "" {
 			w.WriteHeader(http.StatusBadRequest)
-			db.RenderTemplate(w, "add_comment", map[string]interface{}{
+			data := map[string]interface{}{
 				"Title":    "Add Comment",
 				"PostID":   postID,
 				"LoggedIn": userData.LoggedIn,
 				"Username": userData.Username,
-				"Error":    "Content cannot be empty",
-			})
+				"Error":    msg,
+			}
+			if parentID != nil {
+				data["Title"] = "Reply"
+				data["ParentID"] = *parentID
+			}
+			db.RenderTemplate(w, "add_comment", data)
 			return
 		}
 
-		_, err = db.DB.Exec("INSERT INTO comments (post_id, user_id, content) VALUES (?, ?, ?)", postID, userData.UserID, content)
+		commentID, err := h.Comments.Create(postID, userData.UserID, content, parentID)
 		if err != nil {
 			db.HandleError(w, http.StatusInternalServerError, "Failed to add comment")
 			return
 		}
+		h.notifyComment(userData.UserID, postID, commentID, parentID, content)
+		h.publishComment(postID, commentID, userData.UserID)
 
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		http.Redirect(w, r, postURL(postID), http.StatusSeeOther)
 	} else {
 		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
 		return
 	}
 }
 
-func LikeCommentHandler(w http.ResponseWriter, r *http.Request) {
+//EditCommentHandler lets the author of a comment or a moderator change its content
+func (h *Handler) EditCommentHandler(w http.ResponseWriter, r *http.Request) {
 	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
 
-	//extract and validate the comment id from the query parameters
-	commentID, err := strconv.Atoi(r.URL.Query().Get("id"))
-	if err != nil {
-		db.HandleError(w, http.StatusBadRequest, "invalid comment id")
+	if r.Method == http.MethodGet {
+		commentID, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			db.HandleError(w, http.StatusBadRequest, "Invalid comment id")
+			return
+		}
+
+		comment, status, msg := h.checkCommentOwner(commentID, userData)
+		if status != 0 {
+			db.HandleError(w, status, msg)
+			return
+		}
+
+		db.RenderTemplate(w, "add_comment", map[string]interface{}{
+			"Title":     "Edit Comment",
+			"Editing":   true,
+			"CommentID": commentID,
+			"PostID":    comment.PostID,
+			"Content":   comment.Content,
+			"LoggedIn":  userData.LoggedIn,
+			"Username":  userData.Username,
+		})
 		return
 	}
+	if r.Method == http.MethodPost {
+		commentID, err := strconv.Atoi(r.FormValue("comment_id"))
+		if err != nil {
+			db.HandleError(w, http.StatusBadRequest, "Invalid comment id")
+			return
+		}
 
-	//begin a transaction
-	tx, err := db.DB.Begin()
-	if err != nil {
-		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		comment, status, msg := h.checkCommentOwner(commentID, userData)
+		if status != 0 {
+			db.HandleError(w, status, msg)
+			return
+		}
+
+		content := r.FormValue("content")
+		if msg := validateComment(content); msg != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			db.RenderTemplate(w, "add_comment", map[string]interface{}{
+				"Title":     "Edit Comment",
+				"Editing":   true,
+				"CommentID": commentID,
+				"PostID":    comment.PostID,
+				"Content":   content,
+				"LoggedIn":  userData.LoggedIn,
+				"Username":  userData.Username,
+				"Error":     msg,
+			})
+			return
+		}
+
+		if err := h.Comments.Update(commentID, content); err != nil {
+			db.HandleError(w, http.StatusInternalServerError, "Failed to update comment")
+			return
+		}
+
+		http.Redirect(w, r, postURL(comment.PostID), http.StatusSeeOther)
+	} else {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
 		return
 	}
-	defer tx.Rollback()
+}
 
-	//check if a reaction already exists for this comment and user
-	var currentReaction bool
-	err = tx.QueryRow(
-		"SELECT liked FROM comment_reactions WHERE comment_id = ? AND user_id = ?",
-		commentID, userData.UserID,
-	).Scan(&currentReaction)
+//DeleteCommentHandler soft deletes a comment so the thread keeps a "[deleted]" placeholder
+func (h *Handler) DeleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
 
-	if err != nil && err != sql.ErrNoRows {
-		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+	commentID, err := strconv.Atoi(r.FormValue("comment_id"))
+	if err != nil {
+		db.HandleError(w, http.StatusBadRequest, "Invalid comment id")
 		return
 	}
 
-	if err == sql.ErrNoRows {
-		//if no reaction add a new like
-		_, err = tx.Exec(
-			"INSERT INTO comment_reactions (comment_id, user_id, liked) VALUES (?, ?, ?)",
-			commentID, userData.UserID, true,
-		)
-		if err != nil {
-			db.HandleError(w, http.StatusInternalServerError, "Internal server error")
-			return
-		}
-	} else {
-		if currentReaction {
-			//if reaction already exists delete it
-			_, err = tx.Exec(
-				"DELETE FROM comment_reactions WHERE comment_id = ? AND user_id = ?",
-				commentID, userData.UserID,
-			)
-			if err != nil {
-				db.HandleError(w, http.StatusInternalServerError, "Internal server error")
-				return
-			}
-		} else {
-			//if dislike exists change it to like
-			_, err = tx.Exec(
-				"UPDATE comment_reactions SET liked = ? WHERE comment_id = ? AND user_id = ?",
-				true, commentID, userData.UserID,
-			)
-			if err != nil {
-				db.HandleError(w, http.StatusInternalServerError, "Internal server error")
-				return
-			}
-		}
+	comment, status, msg := h.checkCommentOwner(commentID, userData)
+	if status != 0 {
+		db.HandleError(w, status, msg)
+		return
 	}
 
-	//commit the transaction
-	if err = tx.Commit(); err != nil {
-		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+	if err := h.Comments.SoftDelete(commentID); err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Failed to delete comment")
 		return
 	}
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	http.Redirect(w, r, postURL(comment.PostID), http.StatusSeeOther)
 }
 
-func DislikeCommentHandler(w http.ResponseWriter, r *http.Request) {
-	//rtrieve user data from context
-	userData, ok := r.Context().Value(auth.UserKey).(auth.ContextUser)
-	if !ok {
-		db.HandleError(w, http.StatusUnauthorized, "Unauthorized action")
-		return
+//validateComment checks the content of a comment, returning the message to show when it is invalid
+func validateComment(content string) string {
+	if strings.TrimSpace(content) == "" {
+		return "Content cannot be empty"
 	}
+	return ""
+}
 
-	//extract and validate the comment id
-	commentID, err := strconv.Atoi(r.URL.Query().Get("id"))
+//getComment loads a comment that is not deleted, or returns an error status and message
+func (h *Handler) getComment(commentID int) (*store.Comment, int, string) {
+	comment, err := h.Comments.Get(commentID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && comment.Deleted) {
+		return nil, http.StatusNotFound, "Comment not found"
+	}
 	if err != nil {
-		db.HandleError(w, http.StatusBadRequest, "Invalid comment id")
+		return nil, http.StatusInternalServerError, "Internal server error"
+	}
+	return comment, 0, ""
+}
+
+//checkCommentOwner loads the comment, or returns an error status and message
+//unless the comment exists, is not deleted and belongs to the user or the user is a moderator
+func (h *Handler) checkCommentOwner(commentID int, user auth.ContextUser) (*store.Comment, int, string) {
+	comment, status, msg := h.getComment(commentID)
+	if status != 0 {
+		return nil, status, msg
+	}
+	if comment.UserID != user.UserID && !user.CanModerate() {
+		return nil, http.StatusForbidden, "You can only change your own comments"
+	}
+	return comment, 0, ""
+}
+
+func (h *Handler) LikeCommentHandler(w http.ResponseWriter, r *http.Request) {
+	h.reactToComment(w, r, true)
+}
+
+func (h *Handler) DislikeCommentHandler(w http.ResponseWriter, r *http.Request) {
+	h.reactToComment(w, r, false)
+}
+
+func (h *Handler) reactToComment(w http.ResponseWriter, r *http.Request, liked bool) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
 		return
 	}
+	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
 
-	//begin transaction
-	tx, err := db.DB.Begin()
+	//extract and validate the comment id from the form
+	commentID, err := strconv.Atoi(r.FormValue("comment_id"))
 	if err != nil {
-		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		db.HandleError(w, http.StatusBadRequest, "Invalid comment id")
 		return
 	}
-	defer tx.Rollback()
 
-	//check if a reaction exists
-	var currentReaction bool
-	err = tx.QueryRow(
-		"SELECT liked FROM comment_reactions WHERE comment_id = ? AND user_id = ?",
-		commentID, userData.UserID,
-	).Scan(&currentReaction)
-
-	if err != nil && err != sql.ErrNoRows {
-		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+	//the post the comment belongs to is the redirect target
+	comment, status, msg := h.getComment(commentID)
+	if status != 0 {
+		db.HandleError(w, status, msg)
 		return
 	}
-
-	if err == sql.ErrNoRows {
-		//no reaction insert a new dislike
-		_, err = tx.Exec(
-			"INSERT INTO comment_reactions (comment_id, user_id, liked) VALUES (?, ?, ?)",
-			commentID, userData.UserID, false,
-		)
-		if err != nil {
-			db.HandleError(w, http.StatusInternalServerError, "Internal server error")
-			return
-		}
-	} else {
-		if !currentReaction {
-			//already disliked delete
-			_, err = tx.Exec(
-				"DELETE FROM comment_reactions WHERE comment_id = ? AND user_id = ?",
-				commentID, userData.UserID,
-			)
-			if err != nil {
-				db.HandleError(w, http.StatusInternalServerError, "Internal server error")
-				return
-			}
-		} else {
-			//like exists change to dislike
-			_, err = tx.Exec(
-				"UPDATE comment_reactions SET liked = ? WHERE comment_id = ? AND user_id = ?",
-				false, commentID, userData.UserID,
-			)
-			if err != nil {
-				db.HandleError(w, http.StatusInternalServerError, "Internal server error")
-				return
-			}
-		}
+	if _, status, msg := h.getVisiblePost(comment.PostID, userData); status != 0 {
+		db.HandleError(w, status, msg)
+		return
 	}
 
-	//commit
-	if err = tx.Commit(); err != nil {
+	added, err := h.Reactions.ToggleComment(commentID, userData.UserID, liked)
+	if err != nil {
 		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
+	if added {
+		h.notifyReaction(userData.UserID, comment.UserID, comment.PostID, commentID, liked)
+	}
+	h.publishReaction(comment.PostID, commentID)
 
-	//redirect after processing
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	http.Redirect(w, r, postURL(comment.PostID), http.StatusSeeOther)
 }
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"forum/internal/events"
+	"forum/internal/store"
+
+	db "forum/internal/database"
+)
+
+const (
+	// eventBuffer is how many events a page may fall behind before it is dropped
+	eventBuffer     = 32
+	maxEventClients = 1000
+	eventsHeartbeat = 25 * time.Second
+	// eventsWriteTimeout replaces the WriteTimeout of the server for each write of a stream
+	eventsWriteTimeout = 10 * time.Second
+)
+
+//shadowbanned tells whether what the user writes is hidden from everyone else
+func (h *Handler) shadowbanned(userID int) bool {
+	bans, err := h.Bans.Active(userID, time.Now())
+	if err != nil {
+		log.Printf("Failed to load the bans of user %d: %v", userID, err)
+		return true
+	}
+	for _, ban := range bans {
+		if ban.Kind == store.BanShadow {
+			return true
+		}
+	}
+	return false
+}
+
+//publicPost loads a post everyone can see with its categories, false when it is hidden, shadowbanned or gone
+func (h *Handler) publicPost(postID int) (*store.Post, []int, bool) {
+	post, err := h.Posts.Get(postID)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("Failed to load post %d for its event: %v", postID, err)
+		}
+		return nil, nil, false
+	}
+	if post.Hidden || post.Shadowbanned {
+		return nil, nil, false
+	}
+	categoryIDs, err := h.Posts.CategoryIDs(postID)
+	if err != nil {
+		log.Printf("Failed to load the categories of post %d for its event: %v", postID, err)
+		return nil, nil, false
+	}
+	return post, categoryIDs, true
+}
+
+//publishPost tells the open pages about a new post
+func (h *Handler) publishPost(postID int) {
+	post, categoryIDs, ok := h.publicPost(postID)
+	if !ok {
+		return
+	}
+	h.Events.Publish(events.Event{Type: events.TypePost, CategoryIDs: categoryIDs, Data: map[string]interface{}{
+		"post_id": post.ID,
+		"title":   post.Title,
+		"author":  post.Username,
+	}})
+}
+
+//publishComment tells the open pages about a new comment, unless its author is shadowbanned
+func (h *Handler) publishComment(postID, commentID, authorID int) {
+	if h.shadowbanned(authorID) {
+		return
+	}
+	post, categoryIDs, ok := h.publicPost(postID)
+	if !ok {
+		return
+	}
+	h.Events.Publish(events.Event{Type: events.TypeComment, CategoryIDs: categoryIDs, Data: map[string]interface{}{
+		"post_id":       post.ID,
+		"comment_id":    commentID,
+		"comment_count": post.CommentCount,
+	}})
+}
+
+//publishReaction tells the open pages the new like and dislike counts of a post, or of a comment when
+//commentID is set
+func (h *Handler) publishReaction(postID, commentID int) {
+	post, categoryIDs, ok := h.publicPost(postID)
+	if !ok {
+		return
+	}
+	data := map[string]interface{}{"post_id": post.ID, "likes": post.Likes, "dislikes": post.Dislikes}
+	if commentID != 0 {
+		comment, err := h.Comments.Get(commentID)
+		if err != nil {
+			log.Printf("Failed to load comment %d for its event: %v", commentID, err)
+			return
+		}
+		data = map[string]interface{}{"post_id": post.ID, "comment_id": comment.ID, "likes": comment.Likes, "dislikes": comment.Dislikes}
+	}
+	h.Events.Publish(events.Event{Type: events.TypeReaction, CategoryIDs: categoryIDs, Data: data})
+}
+
+//watchedCategories expands the categories picked on the page with their subcategories like the feed
+//filter does, nil when none are picked
+func (h *Handler) watchedCategories(picked []int) (map[int]bool, error) {
+	if len(picked) == 0 {
+		return nil, nil
+	}
+	categories, err := h.Categories.List()
+	if err != nil {
+		return nil, err
+	}
+	watched := make(map[int]bool)
+	for _, id := range picked {
+		watched[id] = true
+	}
+	// parents come before their children
+	for _, c := range categories {
+		if watched[c.ParentID] {
+			watched[c.ID] = true
+		}
+	}
+	return watched, nil
+}
+
+//watches tells whether an event is about a post in the watched categories
+func watches(watched map[int]bool, e events.Event) bool {
+	if watched == nil {
+		return true
+	}
+	for _, id := range e.CategoryIDs {
+		if watched[id] {
+			return true
+		}
+	}
+	return false
+}
+
+//EventsHandler streams the new posts, comments and reaction counts in the categories of the category
+//parameters, or in every category without any, as server-sent events
+func (h *Handler) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	watched, err := h.watchedCategories(parseCategoryIDs(r.URL.Query()["category"]))
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Error loading categories")
+		return
+	}
+	sub := h.Events.Subscribe()
+	if sub == nil {
+		w.Header().Set("Retry-After", "60")
+		db.HandleError(w, http.StatusServiceUnavailable, "Too many live pages open, try again later")
+		return
+	}
+	defer sub.Close()
+
+	// the response controller reaches the connection through the writers wrapping it
+	rc := http.NewResponseController(w)
+	write := func(msg string) bool {
+		if err := rc.SetWriteDeadline(time.Now().Add(eventsWriteTimeout)); err != nil {
+			return false
+		}
+		if _, err := io.WriteString(w, msg); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	if !write("retry: 5000\n\n") {
+		return
+	}
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if !write(": ping\n\n") {
+				return
+			}
+		case e, ok := <-sub.C:
+			// dropped for falling behind, the browser reconnects
+			if !ok {
+				return
+			}
+			if !watches(watched, e) {
+				continue
+			}
+			data, err := json.Marshal(e.Data)
+			if err != nil {
+				log.Printf("Failed to encode %s event: %v", e.Type, err)
+				continue
+			}
+			if !write("event: " + e.Type + "\ndata: " + string(data) + "\n\n") {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"forum/internal/events"
+	"forum/internal/store"
+)
+
+// Handler serves the forum pages on top of the stores
+type Handler struct {
+	Posts         store.PostStore
+	Comments      store.CommentStore
+	Reactions     store.ReactionStore
+	Categories    store.CategoryStore
+	Reports       store.ReportStore
+	Moderation    store.ModerationStore
+	Users         store.UserStore
+	Sessions      store.SessionStore
+	Notifications store.NotificationStore
+	Bans          store.BanStore
+	Messages      store.MessageStore
+	Blocks        store.BlockStore
+	Events        *events.Bus
+	Inbox         *events.Hub
+}
+
+func New(s *store.Stores) *Handler {
+	return &Handler{
+		Posts:         s.Posts,
+		Comments:      s.Comments,
+		Reactions:     s.Reactions,
+		Categories:    s.Categories,
+		Reports:       s.Reports,
+		Moderation:    s.Moderation,
+		Users:         s.Users,
+		Sessions:      s.Sessions,
+		Notifications: s.Notifications,
+		Bans:          s.Bans,
+		Messages:      s.Messages,
+		Blocks:        s.Blocks,
+		Events:        events.NewBus(eventBuffer, maxEventClients),
+		Inbox:         events.NewHub(eventBuffer, maxSockets, maxSocketsPerUser),
+	}
+}
@@ -1,198 +1,139 @@
 package handlers
 
 import (
-	"fmt"
+	"flag"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
-	"strings"
-	"time"
 
 	"forum/internal/auth"
+	"forum/internal/store"
 
 	db "forum/internal/database"
 )
 
-type Category struct {
-	ID          int
-	Name        string
-	Description string
-}
-
-type Comment struct {
-	ID        int
-	PostID    int
-	UserID    int
-	Username  string
-	Content   string
-	CreatedAt time.Time
-	Likes     int
-	Dislikes  int
-}
+const (
+	defaultPageSize = 10
+	maxPageSize     = 50
+)
 
-type Post struct {
-	ID         int
-	UserID     int
-	Username   string
-	Title      string
-	Content    string
-	CreatedAt  time.Time
-	Likes      int
-	Dislikes   int
-	Categories []string
-	Comments   []Comment
-}
+var maxCommentDepth = flag.Int("comment-depth", 5, "Maximum nesting depth of comment replies")
 
-func buildPostsQuery(r *http.Request, userData auth.ContextUser) (string, []interface{}) {
-	baseQuery := `
-	SELECT 
-	    p.id, 
-	    p.title, 
-	    p.content, 
-	    p.created_at, 
-	    u.username,
-	    (SELECT COUNT(*) FROM post_reactions pr WHERE pr.post_id = p.id AND pr.liked = 1) AS likes,
-	    (SELECT COUNT(*) FROM post_reactions pr WHERE pr.post_id = p.id AND pr.liked = 0) AS dislikes,
-	    COALESCE(GROUP_CONCAT(DISTINCT c.name), '') AS categories
-	FROM posts p
-	JOIN users u ON p.user_id = u.id
-	LEFT JOIN post_categories pc ON p.id = pc.post_id
-	LEFT JOIN categories c ON pc.category_id = c.id
-	WHERE 1=1
-	`
-	var args []interface{}
-	q := r.URL.Query()
-	if categories, exists := q["category"]; exists && len(categories) > 0 {
-		baseQuery += " AND c.id IN ("
-		for i, category := range categories {
-			if i > 0 {
-				baseQuery += ", "
-			}
-			baseQuery += "?"
-			args = append(args, category)
-		}
-		baseQuery += ")"
+// buildPostFilter reads the category, created, liked and sort parameters of the feed
+func buildPostFilter(q url.Values, userData auth.ContextUser) store.PostFilter {
+	filter := store.PostFilter{
+		CategoryIDs: parseCategoryIDs(q["category"]),
+		Sort:        q.Get("sort"),
+		ViewerID:    userData.UserID,
 	}
-
 	if q.Get("created") == "1" && userData.LoggedIn {
-		baseQuery += " AND p.user_id = ?"
-		args = append(args, userData.UserID)
+		filter.AuthorID = userData.UserID
 	}
-
 	if q.Get("liked") == "1" && userData.LoggedIn {
-		baseQuery += " AND p.id IN (SELECT post_id FROM post_reactions WHERE user_id = ? AND liked = 1)"
-		args = append(args, userData.UserID)
+		filter.LikedBy = userData.UserID
 	}
-
-	// complete the query with grouping and ordering.
-	baseQuery += " GROUP BY p.id ORDER BY p.created_at DESC"
-
-	return baseQuery, args
+	return filter
 }
 
-func fetchPosts(query string, args []interface{}) ([]Post, []int, error) {
-	rows, err := db.DB.Query(query, args...)
+// loadComments attaches the comment tree of each post as the viewer sees it
+func (h *Handler) loadComments(posts []store.Post, viewerID int) error {
+	postIDs := make([]int, len(posts))
+	for i, post := range posts {
+		postIDs[i] = post.ID
+	}
+	commentsMap, err := h.Comments.ListByPosts(postIDs, viewerID)
 	if err != nil {
-		return nil, nil, err
-	}
-	defer rows.Close()
-
-	var posts []Post
-	var postIDs []int
-	for rows.Next() {
-		var post Post
-		var categoriesStr string
-
-		if err := rows.Scan(
-			&post.ID,
-			&post.Title,
-			&post.Content,
-			&post.CreatedAt,
-			&post.Username,
-			&post.Likes,
-			&post.Dislikes,
-			&categoriesStr,
-		); err != nil {
-			return nil, nil, err
-		}
-		if categoriesStr != "" {
-			post.Categories = strings.Split(categoriesStr, ",")
-		} else {
-			post.Categories = []string{}
-		}
-		posts = append(posts, post)
-		postIDs = append(postIDs, post.ID)
+		return err
 	}
-	if err := rows.Err(); err != nil {
-		log.Println("Error scanning post:", err)
-		return nil, nil, err
+	for i, post := range posts {
+		posts[i].Comments = buildCommentTree(commentsMap[post.ID])
 	}
-	return posts, postIDs, nil
+	return nil
 }
 
-func fetchComments(postIDs []int) (map[int][]Comment, error) {
-	if len(postIDs) == 0 {
-		return make(map[int][]Comment), nil
-	}
-
-	// build a placeholder string (e.g., "?, ?, ?") for the SQL IN clause.
-	placeholders := make([]string, len(postIDs))
-	args := make([]interface{}, len(postIDs))
-	for i, id := range postIDs {
-		placeholders[i] = "?"
-		args[i] = id
-	}
-
-	query := `
-		SELECT cm.id, cm.post_id, cm.user_id, u.username, cm.content, cm.created_at, 
-		(SELECT COUNT(*) FROM comment_reactions cr WHERE cr.comment_id = cm.id AND cr.liked = 1) AS likes,
-		(SELECT COUNT(*) FROM comment_reactions cr WHERE cr.comment_id = cm.id AND cr.liked = 0) AS dislikes
-		FROM comments cm
-		JOIN users u ON cm.user_id = u.id
-		WHERE cm.post_id IN (` + strings.Join(placeholders, ",") + `)
-		ORDER BY cm.created_at DESC
-		`
-	rows, err := db.DB.Query(query, args...)
-	if err != nil {
-		return nil, err
+// buildCommentTree nests the comments of a post, given oldest first, under their parent.
+// Top level comments come newest first and replies oldest first; replies deeper than
+// maxCommentDepth are listed flat at that depth so no part of a thread is lost.
+func buildCommentTree(comments []store.Comment) []store.Comment {
+	known := make(map[int]bool)
+	for _, c := range comments {
+		known[c.ID] = true
+	}
+
+	children := make(map[int][]store.Comment)
+	var roots []store.Comment
+	for _, c := range comments {
+		if c.ParentID != nil && known[*c.ParentID] {
+			children[*c.ParentID] = append(children[*c.ParentID], c)
+		} else {
+			roots = append(roots, c)
+		}
 	}
-	defer rows.Close()
 
-	commentsMap := make(map[int][]Comment)
-	for rows.Next() {
-		var c Comment
-		if err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Username, &c.Content, &c.CreatedAt, &c.Likes, &c.Dislikes); err != nil {
-			// log error and continue with other comments.
-			fmt.Println("Error at rows scan comment", err)
-			continue
+	// descendants lists the whole subtree under id in the order of the thread
+	var descendants func(id int) []store.Comment
+	descendants = func(id int) []store.Comment {
+		var list []store.Comment
+		for _, child := range children[id] {
+			list = append(list, child)
+			list = append(list, descendants(child.ID)...)
 		}
-		commentsMap[c.PostID] = append(commentsMap[c.PostID], c)
-		// log.Printf("Comment ID %d: Likes = %d, Dislikes = %d", c.ID, c.Likes, c.Dislikes)
+		return list
+	}
 
+	var attach func(c store.Comment, depth int) store.Comment
+	attach = func(c store.Comment, depth int) store.Comment {
+		c.Depth = depth
+		for _, child := range children[c.ID] {
+			if depth+1 < *maxCommentDepth {
+				c.Replies = append(c.Replies, attach(child, depth+1))
+				continue
+			}
+			child.Depth = depth + 1
+			c.Replies = append(c.Replies, child)
+			for _, d := range descendants(child.ID) {
+				d.Depth = depth + 1
+				c.Replies = append(c.Replies, d)
+			}
+		}
+		return c
 	}
 
-	return commentsMap, nil
+	tree := make([]store.Comment, 0, len(roots))
+	for i := len(roots) - 1; i >= 0; i-- {
+		tree = append(tree, attach(roots[i], 0))
+	}
+	return tree
 }
 
-func getCategories() ([]Category, error) {
-	rows, err := db.DB.Query("SELECT id, name, description FROM categories")
-	if err != nil {
-		return nil, err
+// parsePage reads the page and limit parameters of the feed, falling back to defaults.
+func parsePage(q url.Values) (int, int) {
+	page, err := strconv.Atoi(q.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit < 1 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
 	}
-	defer rows.Close()
+	return page, limit
+}
 
-	var categories []Category
-	for rows.Next() {
-		var cat Category
-		if err := rows.Scan(&cat.ID, &cat.Name, &cat.Description); err != nil {
-			return nil, err
-		}
-		categories = append(categories, cat)
+// pageURL links to another page of the feed keeping the current filters and sort.
+func pageURL(q url.Values, page int) string {
+	params := url.Values{}
+	for key, values := range q {
+		params[key] = values
 	}
-	return categories, nil
+	params.Set("page", strconv.Itoa(page))
+	return "/?" + params.Encode()
 }
 
-func HomeHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) HomeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
 		db.HandleError(w, http.StatusNotFound, "Page not found")
 		return
@@ -204,48 +145,57 @@ func HomeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	userData, _ := r.Context().Value(auth.UserKey).(auth.ContextUser)
+	q := r.URL.Query()
 
-	query, args := buildPostsQuery(r, userData)
+	// one extra post only tells that there is a next page.
+	page, limit := parsePage(q)
+	filter := buildPostFilter(q, userData)
+	filter.Limit, filter.Offset = limit+1, (page-1)*limit
 
-	posts, postIDs, err := fetchPosts(query, args)
+	posts, err := h.Posts.List(filter)
 	if err != nil {
+		log.Println("Error loading posts:", err)
 		db.HandleError(w, http.StatusInternalServerError, "Error loading posts")
 		return
 	}
-	categories, err := getCategories()
+	hasNext := len(posts) > limit
+	if hasNext {
+		posts = posts[:limit]
+	}
+
+	categories, err := h.Categories.List()
 	if err != nil {
 		db.HandleError(w, http.StatusInternalServerError, "Error loading categories")
 		return
 	}
-	commentsMap, err := fetchComments(postIDs)
-	if err != nil {
-		log.Fatal("Error fetching comments ", err)
+	if err := h.loadComments(posts, userData.UserID); err != nil {
+		log.Println("Error fetching comments:", err)
 		db.HandleError(w, http.StatusInternalServerError, "Error loading comments")
 		return
 	}
-	// attach loaded comments to the corresponding posts.
-	for i, post := range posts {
-		posts[i].Comments = commentsMap[post.ID]
-	}
-
-	selectedCategoryIDs := r.URL.Query()["category"] // Query returns a slice of values
-	var selectedCategories []int
-	for _, catID := range selectedCategoryIDs {
-		if id, err := strconv.Atoi(catID); err == nil {
-			selectedCategories = append(selectedCategories, id)
-		}
-	}
 
 	// prepare data for the template.
 	data := map[string]interface{}{
 		"Title":              "Home Page",
 		"LoggedIn":           userData.LoggedIn,
 		"Username":           userData.Username,
+		"UserID":             userData.UserID,
+		"CanModerate":        userData.CanModerate(),
+		"Unread":             h.unreadNotifications(userData),
+		"UnreadMessages":     h.unreadMessages(userData),
 		"Posts":              posts,
 		"FilterCategories":   categories,
-		"SelectedCategories": selectedCategories,
-		"FilterCreated":      r.URL.Query().Get("created") == "1",
-		"FilterLiked":        r.URL.Query().Get("liked") == "1",
+		"SelectedCategories": filter.CategoryIDs,
+		"FilterCreated":      q.Get("created") == "1",
+		"FilterLiked":        q.Get("liked") == "1",
+		"Sort":               q.Get("sort"),
+		"Page":               page,
+	}
+	if page > 1 {
+		data["PrevURL"] = pageURL(q, page-1)
+	}
+	if hasNext {
+		data["NextURL"] = pageURL(q, page+1)
 	}
 
 	// render the home page template with the collected data.
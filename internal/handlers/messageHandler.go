@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"forum/internal/auth"
+	"forum/internal/store"
+
+	db "forum/internal/database"
+)
+
+const (
+	maxMessageLength = 2000
+	// messagesShown is how many of the latest messages a conversation page shows
+	messagesShown = 100
+)
+
+//unreadMessages counts the unread private messages of the user for the header, 0 when logged out
+func (h *Handler) unreadMessages(userData auth.ContextUser) int {
+	if !userData.LoggedIn {
+		return 0
+	}
+	count, err := h.Messages.CountUnread(userData.UserID)
+	if err != nil {
+		log.Println("Error counting messages:", err)
+	}
+	return count
+}
+
+//checkMessage tells whether the user may send the body to the other user, returning it trimmed, or an error
+//status and message when they may not
+func (h *Handler) checkMessage(userData auth.ContextUser, otherID int, body string) (string, int, string) {
+	if !userData.CanPost() {
+		return "", http.StatusForbidden, "Please verify your email address first. You can resend the link from your account page."
+	}
+	body = strings.TrimSpace(body)
+	if body == "" || utf8.RuneCountInString(body) > maxMessageLength {
+		return "", http.StatusBadRequest, "Write a message of at most 2000 characters."
+	}
+	blocked, err := h.Blocks.Between(userData.UserID, otherID)
+	if err != nil {
+		log.Println("Error checking blocks:", err)
+		return "", http.StatusInternalServerError, "Internal server error"
+	}
+	if blocked {
+		return "", http.StatusForbidden, "You cannot message this user."
+	}
+	return body, 0, ""
+}
+
+//postMessage stores a checked message in a conversation of the user and delivers it to the open pages
+func (h *Handler) postMessage(userData auth.ContextUser, conversation *store.Conversation, body string) (*store.Message, error) {
+	m := &store.Message{ConversationID: conversation.ID, SenderID: userData.UserID, SenderName: userData.Username, Body: body}
+	if err := h.Messages.Send(m); err != nil {
+		log.Println("Error sending message:", err)
+		return nil, err
+	}
+	h.deliverMessage(m, conversation.OtherID)
+	return m, nil
+}
+
+//sendMessage sends a message in a conversation of the user and delivers it to the open pages, returning
+//an error status and message when it cannot be sent
+func (h *Handler) sendMessage(userData auth.ContextUser, conversation *store.Conversation, body string) (*store.Message, int, string) {
+	body, status, msg := h.checkMessage(userData, conversation.OtherID, body)
+	if status != 0 {
+		return nil, status, msg
+	}
+	m, err := h.postMessage(userData, conversation, body)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Failed to send the message"
+	}
+	return m, 0, ""
+}
+
+//renderMessages shows the inbox of the user, data holds what the new message form needs on top of it
+func (h *Handler) renderMessages(w http.ResponseWriter, userData auth.ContextUser, data map[string]interface{}) {
+	conversations, err := h.Messages.ListByUser(userData.UserID)
+	if err != nil {
+		log.Println("Error loading conversations:", err)
+		db.HandleError(w, http.StatusInternalServerError, "Error loading conversations")
+		return
+	}
+	blocked, err := h.Blocks.ListBlocked(userData.UserID)
+	if err != nil {
+		log.Println("Error loading blocked users:", err)
+		db.HandleError(w, http.StatusInternalServerError, "Error loading blocked users")
+		return
+	}
+	data["Title"] = "Messages"
+	data["LoggedIn"] = userData.LoggedIn
+	data["Username"] = userData.Username
+	data["UserID"] = userData.UserID
+	data["Conversations"] = conversations
+	data["Blocked"] = blocked
+	data["Unread"] = h.unreadMessages(userData)
+	db.RenderTemplate(w, "messages", data)
+}
+
+//MessagesHandler lists the conversations of the user with their unread counts, and the users they blocked
+func (h *Handler) MessagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
+
+	h.renderMessages(w, userData, map[string]interface{}{"To": r.URL.Query().Get("to")})
+}
+
+//NewMessageHandler sends a message to a username, in the conversation the two already have if there is one
+func (h *Handler) NewMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
+
+	to := strings.TrimSpace(r.FormValue("to"))
+	body := r.FormValue("body")
+	formError := func(status int, msg string) {
+		w.WriteHeader(status)
+		h.renderMessages(w, userData, map[string]interface{}{"Error": msg, "To": to, "Body": body})
+	}
+
+	other, err := h.Users.GetByUsername(to)
+	if errors.Is(err, store.ErrNotFound) {
+		formError(http.StatusBadRequest, "There is no user with that name.")
+		return
+	}
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if other.ID == userData.UserID {
+		formError(http.StatusBadRequest, "You cannot message yourself.")
+		return
+	}
+
+	//no conversation is started with a user the message cannot go to
+	checked, status, msg := h.checkMessage(userData, other.ID, body)
+	if status != 0 {
+		formError(status, msg)
+		return
+	}
+
+	conversation, err := h.Messages.Open(userData.UserID, other.ID)
+	if err != nil {
+		log.Println("Error opening conversation:", err)
+		db.HandleError(w, http.StatusInternalServerError, "Failed to start the conversation")
+		return
+	}
+	if _, err := h.postMessage(userData, conversation, checked); err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Failed to send the message")
+		return
+	}
+	http.Redirect(w, r, "/conversation?id="+strconv.Itoa(conversation.ID), http.StatusSeeOther)
+}
+
+//ConversationHandler shows the latest messages of a conversation of the user and marks them read
+func (h *Handler) ConversationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
+
+	conversationID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		db.HandleError(w, http.StatusBadRequest, "Invalid conversation id")
+		return
+	}
+	conversation, err := h.Messages.Get(conversationID, userData.UserID)
+	if errors.Is(err, store.ErrNotFound) {
+		db.HandleError(w, http.StatusNotFound, "Conversation not found")
+		return
+	}
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Error loading conversation")
+		return
+	}
+	messages, err := h.Messages.ListMessages(conversation.ID, userData.UserID, messagesShown)
+	if err != nil {
+		log.Println("Error loading messages:", err)
+		db.HandleError(w, http.StatusInternalServerError, "Error loading messages")
+		return
+	}
+	if conversation.Unread > 0 && len(messages) > 0 {
+		if err := h.Messages.MarkRead(conversation.ID, userData.UserID, messages[len(messages)-1].ID); err != nil {
+			log.Printf("Failed to mark conversation %d read: %v", conversation.ID, err)
+		}
+		h.sendUnread(userData.UserID)
+	}
+
+	blocked, err := h.Blocks.Between(userData.UserID, conversation.OtherID)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	blocks, err := h.Blocks.ListBlocked(userData.UserID)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	blocking := false
+	for _, b := range blocks {
+		if b.UserID == conversation.OtherID {
+			blocking = true
+		}
+	}
+
+	db.RenderTemplate(w, "conversation", map[string]interface{}{
+		"Title":        "Conversation with " + conversation.OtherName,
+		"LoggedIn":     userData.LoggedIn,
+		"Username":     userData.Username,
+		"UserID":       userData.UserID,
+		"Conversation": conversation,
+		"Messages":     messages,
+		"Blocked":      blocked,
+		"Blocking":     blocking,
+		"CanPost":      userData.CanPost(),
+	})
+}
+
+//SendMessageHandler sends a message in a conversation from its form, for pages without the WebSocket
+func (h *Handler) SendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
+
+	conversationID, err := strconv.Atoi(r.FormValue("conversation_id"))
+	if err != nil {
+		db.HandleError(w, http.StatusBadRequest, "Invalid conversation id")
+		return
+	}
+	conversation, err := h.Messages.Get(conversationID, userData.UserID)
+	if errors.Is(err, store.ErrNotFound) {
+		db.HandleError(w, http.StatusNotFound, "Conversation not found")
+		return
+	}
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Error loading conversation")
+		return
+	}
+	m, status, msg := h.sendMessage(userData, conversation, r.FormValue("body"))
+	if status != 0 {
+		db.HandleError(w, status, msg)
+		return
+	}
+	http.Redirect(w, r, "/conversation?id="+strconv.Itoa(conversation.ID)+"#message-"+strconv.Itoa(m.ID), http.StatusSeeOther)
+}
+
+//blockTarget reads the user a block form is about, writing the error when it is not valid
+func (h *Handler) blockTarget(w http.ResponseWriter, r *http.Request, userData auth.ContextUser) (*store.User, bool) {
+	userID, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		db.HandleError(w, http.StatusBadRequest, "Invalid user id")
+		return nil, false
+	}
+	if userID == userData.UserID {
+		db.HandleError(w, http.StatusBadRequest, "You cannot block yourself")
+		return nil, false
+	}
+	user, err := h.Users.GetByID(userID)
+	if errors.Is(err, store.ErrNotFound) {
+		db.HandleError(w, http.StatusNotFound, "User not found")
+		return nil, false
+	}
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+		return nil, false
+	}
+	return user, true
+}
+
+//blockRedirect goes back to the conversation the block form was on, or to the inbox
+func blockRedirect(w http.ResponseWriter, r *http.Request) {
+	if conversationID, err := strconv.Atoi(r.FormValue("conversation_id")); err == nil {
+		http.Redirect(w, r, "/conversation?id="+strconv.Itoa(conversationID), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/messages", http.StatusSeeOther)
+}
+
+//BlockUserHandler blocks a user, after which neither can message the other
+func (h *Handler) BlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
+
+	user, ok := h.blockTarget(w, r, userData)
+	if !ok {
+		return
+	}
+	if err := h.Blocks.Block(userData.UserID, user.ID); err != nil {
+		log.Println("Error blocking user:", err)
+		db.HandleError(w, http.StatusInternalServerError, "Failed to block the user")
+		return
+	}
+	blockRedirect(w, r)
+}
+
+//UnblockUserHandler lifts a block of the user
+func (h *Handler) UnblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
+
+	user, ok := h.blockTarget(w, r, userData)
+	if !ok {
+		return
+	}
+	err := h.Blocks.Unblock(userData.UserID, user.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		db.HandleError(w, http.StatusNotFound, "You have not blocked this user")
+		return
+	}
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Failed to unblock the user")
+		return
+	}
+	blockRedirect(w, r)
+}
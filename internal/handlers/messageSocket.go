@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"forum/internal/auth"
+	"forum/internal/events"
+	"forum/internal/store"
+	"forum/internal/websocket"
+
+	db "forum/internal/database"
+)
+
+const (
+	// maxSockets bounds the open message pages, maxSocketsPerUser the ones of a single user
+	maxSockets        = 1000
+	maxSocketsPerUser = 10
+	// maxSocketMessage is the longest request a page may send, a message of maxMessageLength fits
+	maxSocketMessage = 16 << 10
+	// a page that does not answer the pings for socketReadTimeout is dropped
+	socketPing        = 30 * time.Second
+	socketReadTimeout = 60 * time.Second
+	// typingInterval is how often a page may tell the other user someone is typing
+	typingInterval = 2 * time.Second
+)
+
+// socketRequest is what the message pages send over the WebSocket: "send" a Body,
+// "typing", or "read" up to MessageID, in a conversation
+type socketRequest struct {
+	Type           string `json:"type"`
+	ConversationID int    `json:"conversation_id"`
+	Body           string `json:"body"`
+	MessageID      int    `json:"message_id"`
+}
+
+// socketFrame is what the WebSocket sends to the pages, an event or an error
+type socketFrame struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// socketMessage is a message as the WebSocket delivers it
+type socketMessage struct {
+	ID             int       `json:"id"`
+	ConversationID int       `json:"conversation_id"`
+	SenderID       int       `json:"sender_id"`
+	Sender         string    `json:"sender"`
+	Body           string    `json:"body"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+//deliverMessage hands a new message to the open pages of its sender and of the recipient, unless the
+//sender is shadowbanned
+func (h *Handler) deliverMessage(m *store.Message, recipientID int) {
+	e := events.Event{Type: events.TypeMessage, Data: socketMessage{
+		ID:             m.ID,
+		ConversationID: m.ConversationID,
+		SenderID:       m.SenderID,
+		Sender:         m.SenderName,
+		Body:           m.Body,
+		CreatedAt:      m.CreatedAt,
+	}}
+	h.Inbox.Send(m.SenderID, e)
+	if h.shadowbanned(m.SenderID) {
+		return
+	}
+	h.Inbox.Send(recipientID, e)
+	h.sendUnread(recipientID)
+}
+
+//sendUnread hands the count of unread messages of the user to their open pages
+func (h *Handler) sendUnread(userID int) {
+	count, err := h.Messages.CountUnread(userID)
+	if err != nil {
+		log.Printf("Failed to count the unread messages of user %d: %v", userID, err)
+		return
+	}
+	h.Inbox.Send(userID, events.Event{Type: events.TypeUnread, Data: map[string]interface{}{"count": count}})
+}
+
+//sessionActive tells whether a session is still there, it is gone once its user logged out, was banned or
+//let it expire
+func (h *Handler) sessionActive(sessionID string) bool {
+	_, err := h.Sessions.Get(sessionID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Println("Error during session lookup:", err)
+	}
+	return err == nil
+}
+
+//writeSocket sends an event to the page
+func writeSocket(conn *websocket.Conn, e events.Event) error {
+	data, err := json.Marshal(socketFrame{Type: e.Type, Data: e.Data})
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(data)
+}
+
+//socketError tells the page a request failed, the connection stays open
+func socketError(conn *websocket.Conn, msg string) error {
+	return writeSocket(conn, events.Event{Type: "error", Data: map[string]interface{}{"error": msg}})
+}
+
+//handleSocketRequest carries out a request of a message page, only failing when the page cannot be written to
+func (h *Handler) handleSocketRequest(conn *websocket.Conn, userData auth.ContextUser, req socketRequest, lastTyping *time.Time) error {
+	switch req.Type {
+	case "send":
+		conversation, err := h.Messages.Get(req.ConversationID, userData.UserID)
+		if errors.Is(err, store.ErrNotFound) {
+			return socketError(conn, "Conversation not found")
+		}
+		if err != nil {
+			return socketError(conn, "Error loading conversation")
+		}
+		if _, status, msg := h.sendMessage(userData, conversation, req.Body); status != 0 {
+			return socketError(conn, msg)
+		}
+	case "typing":
+		if time.Since(*lastTyping) < typingInterval {
+			return nil
+		}
+		*lastTyping = time.Now()
+		conversation, err := h.Messages.Get(req.ConversationID, userData.UserID)
+		if err != nil {
+			return nil
+		}
+		blocked, err := h.Blocks.Between(userData.UserID, conversation.OtherID)
+		if err != nil || blocked || h.shadowbanned(userData.UserID) {
+			return nil
+		}
+		h.Inbox.Send(conversation.OtherID, events.Event{Type: events.TypeTyping, Data: map[string]interface{}{
+			"conversation_id": conversation.ID,
+			"user":            userData.Username,
+		}})
+	case "read":
+		if err := h.Messages.MarkRead(req.ConversationID, userData.UserID, req.MessageID); err != nil {
+			log.Printf("Failed to mark conversation %d read: %v", req.ConversationID, err)
+			return socketError(conn, "Failed to mark the conversation read")
+		}
+		h.sendUnread(userData.UserID)
+	default:
+		return socketError(conn, "Unknown request")
+	}
+	return nil
+}
+
+//MessagesSocketHandler upgrades a message page to a WebSocket delivering the new messages, typing indicators
+//and unread counts of the user live, and taking their messages, typing and read marks. It is authenticated
+//by the session cookie like every page, and ends once the session does.
+func (h *Handler) MessagesSocketHandler(w http.ResponseWriter, r *http.Request) {
+	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
+
+	if status, msg := websocket.Check(r); status != 0 {
+		db.HandleError(w, status, msg)
+		return
+	}
+	sub := h.Inbox.Subscribe(userData.UserID)
+	if sub == nil {
+		w.Header().Set("Retry-After", "60")
+		db.HandleError(w, http.StatusServiceUnavailable, "Too many message pages open, try again later")
+		return
+	}
+	defer sub.Close()
+
+	conn, err := websocket.Upgrade(w, r, maxSocketMessage, socketReadTimeout)
+	if err != nil {
+		log.Println("Failed to upgrade to a WebSocket:", err)
+		return
+	}
+	defer conn.Close()
+
+	// requests are handled as they are read while events are written below, the writes do not interleave
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var lastTyping time.Time
+		for {
+			data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var req socketRequest
+			if err := json.Unmarshal(data, &req); err != nil {
+				if socketError(conn, "Invalid request") != nil {
+					return
+				}
+				continue
+			}
+			if h.handleSocketRequest(conn, userData, req, &lastTyping) != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(socketPing)
+	defer ping.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ping.C:
+			if !h.sessionActive(userData.SessionID) {
+				conn.CloseWith(websocket.ClosePolicy, "Session ended")
+				return
+			}
+			if conn.Ping() != nil {
+				return
+			}
+		case e, ok := <-sub.C:
+			// dropped for falling behind, the page reconnects
+			if !ok {
+				conn.CloseWith(websocket.CloseGoingAway, "Fell behind")
+				return
+			}
+			if writeSocket(conn, e) != nil {
+				return
+			}
+		}
+	}
+}
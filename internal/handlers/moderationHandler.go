@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/internal/auth"
+	"forum/internal/store"
+
+	db "forum/internal/database"
+)
+
+// actions of the moderators, as written to the moderation log
+const (
+	actionDismiss = "dismiss"
+	actionHide    = "hide"
+	actionUnhide  = "unhide"
+	actionDelete  = "delete"
+	actionWarn    = "warn"
+)
+
+const (
+	maxModerationNote = 500
+	moderationLogSize = 200
+)
+
+//queueItem is reported content with its open reports, oldest first
+type queueItem struct {
+	reportedContent
+	Reports []store.Report
+}
+
+//ModerationQueueHandler lists the open reports grouped by the content they are about, with the content inline
+func (h *Handler) ModerationQueueHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
+
+	reports, err := h.Reports.ListOpen()
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Error loading reports")
+		return
+	}
+
+	// the content reported first comes first
+	var items []*queueItem
+	byContent := make(map[[2]int]*queueItem)
+	for _, report := range reports {
+		key := [2]int{report.PostID, report.CommentID}
+		item, ok := byContent[key]
+		if !ok {
+			content, status, _ := h.loadReported(report.PostID, report.CommentID)
+			if status == http.StatusInternalServerError {
+				db.HandleError(w, status, "Error loading reports")
+				return
+			}
+			item = &queueItem{reportedContent: content}
+			byContent[key] = item
+			if status == 0 {
+				items = append(items, item)
+			}
+		}
+		item.Reports = append(item.Reports, report)
+	}
+
+	db.RenderTemplate(w, "moderation", map[string]interface{}{
+		"Title":        "Moderation",
+		"LoggedIn":     userData.LoggedIn,
+		"Username":     userData.Username,
+		"Items":        items,
+		"ReasonLabels": reasonLabels(),
+	})
+}
+
+//ResolveReportHandler closes the open reports on some content with one action: dismiss them, hide or
+//delete the content, or warn its author. The action goes to the moderation log.
+func (h *Handler) ResolveReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
+
+	reportID, err := strconv.Atoi(r.FormValue("report_id"))
+	if err != nil {
+		db.HandleError(w, http.StatusBadRequest, "Invalid report id")
+		return
+	}
+	if status, msg := h.resolveReport(userData, reportID, r.FormValue("action"), r.FormValue("note")); status != 0 {
+		db.HandleError(w, status, msg)
+		return
+	}
+	http.Redirect(w, r, "/moderation", http.StatusSeeOther)
+}
+
+//resolveReport takes the action of a moderator on a report, returning an error status and message when
+//it cannot
+func (h *Handler) resolveReport(userData auth.ContextUser, reportID int, action, note string) (int, string) {
+	note = strings.TrimSpace(note)
+	if len(note) > maxModerationNote {
+		return http.StatusBadRequest, "Notes must be at most 500 characters"
+	}
+
+	report, err := h.Reports.Get(reportID)
+	if errors.Is(err, store.ErrNotFound) {
+		return http.StatusNotFound, "Report not found"
+	}
+	if err != nil {
+		return http.StatusInternalServerError, "Internal server error"
+	}
+	if report.ResolvedAt != nil {
+		return http.StatusConflict, "This report is already resolved"
+	}
+	content, status, msg := h.loadReported(report.PostID, report.CommentID)
+	if status != 0 {
+		return status, msg
+	}
+
+	now := time.Now()
+	//the action, closing the reports and the log entry happen together
+	a := &store.ModerationAction{
+		Entry: store.ModerationEntry{
+			ModeratorID:  userData.UserID,
+			Action:       action,
+			ReportID:     report.ID,
+			PostID:       report.PostID,
+			CommentID:    report.CommentID,
+			TargetUserID: content.AuthorID(),
+			Note:         note,
+		},
+		ResolveAt: &now,
+	}
+	switch action {
+	case actionDismiss:
+	case actionHide:
+		hide := true
+		a.Hide = &hide
+	case actionDelete:
+		a.Delete = true
+	case actionWarn:
+		reason := note
+		if reason == "" {
+			reason = "Your content was reported for: " + reasonLabels()[report.Reason]
+		}
+		a.Warning = &store.Warning{UserID: content.AuthorID(), ModeratorID: userData.UserID, Reason: reason}
+	default:
+		return http.StatusBadRequest, "Invalid action"
+	}
+	if err := h.Moderation.Apply(a); err != nil {
+		log.Printf("Moderation %s of report %d failed: %v", action, reportID, err)
+		return http.StatusInternalServerError, "Failed to resolve report"
+	}
+	return 0, ""
+}
+
+//UnhideHandler shows a hidden post or comment again, for moderators
+func (h *Handler) UnhideHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
+
+	postID, _ := strconv.Atoi(r.FormValue("post_id"))
+	commentID, _ := strconv.Atoi(r.FormValue("comment_id"))
+	if (postID == 0) == (commentID == 0) {
+		db.HandleError(w, http.StatusBadRequest, "Invalid content id")
+		return
+	}
+	content, status, msg := h.loadReported(postID, commentID)
+	if status != 0 {
+		db.HandleError(w, status, msg)
+		return
+	}
+
+	hide := false
+	a := &store.ModerationAction{
+		Entry: store.ModerationEntry{
+			ModeratorID:  userData.UserID,
+			Action:       actionUnhide,
+			PostID:       postID,
+			CommentID:    commentID,
+			TargetUserID: content.AuthorID(),
+		},
+		Hide: &hide,
+	}
+	if err := h.Moderation.Apply(a); err != nil {
+		log.Printf("Moderation %s failed: %v", actionUnhide, err)
+		db.HandleError(w, http.StatusInternalServerError, "Failed to unhide content")
+		return
+	}
+
+	http.Redirect(w, r, postURL(content.PostID()), http.StatusSeeOther)
+}
+
+//ModerationLogHandler lists the latest moderation actions
+func (h *Handler) ModerationLogHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
+
+	entries, err := h.Moderation.ListLog(moderationLogSize)
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Error loading the moderation log")
+		return
+	}
+
+	db.RenderTemplate(w, "moderation_log", map[string]interface{}{
+		"Title":    "Moderation log",
+		"LoggedIn": userData.LoggedIn,
+		"Username": userData.Username,
+		"Entries":  entries,
+	})
+}
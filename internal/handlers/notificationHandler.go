@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/internal/auth"
+	"forum/internal/store"
+
+	db "forum/internal/database"
+)
+
+const (
+	notificationsShown = 100
+	// maxMentions bounds the users one post or comment can notify by their @username
+	maxMentions = 10
+)
+
+// mentionPattern finds the @usernames in a text, a mention ends at the first
+// character that is not a letter, a digit, _, . or -
+var mentionPattern = regexp.MustCompile(`@([\p{L}\p{N}_.\-]+)`)
+
+//notify creates a notification unless the user caused it themselves, a failure is only logged
+//since what caused it already happened
+func (h *Handler) notify(n store.Notification) {
+	if n.UserID == 0 || n.UserID == n.ActorID {
+		return
+	}
+	if err := h.Notifications.Create(&n); err != nil {
+		log.Printf("Failed to notify user %d of %s on post %d: %v", n.UserID, n.Kind, n.PostID, err)
+	}
+}
+
+//notifyMentions notifies the users mentioned in a post or a comment, skipping the ones in notified
+func (h *Handler) notifyMentions(actorID, postID, commentID int, content string, notified map[int]bool) {
+	matches := mentionPattern.FindAllStringSubmatch(content, -1)
+	seen := make(map[string]bool)
+	for _, match := range matches {
+		name := strings.TrimRight(match[1], ".-")
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		if len(seen) > maxMentions {
+			return
+		}
+
+		user, err := h.Users.GetByUsername(name)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to look up mentioned user %q: %v", name, err)
+			continue
+		}
+		if notified[user.ID] {
+			continue
+		}
+		notified[user.ID] = true
+		h.notify(store.Notification{UserID: user.ID, ActorID: actorID, Kind: store.NotifyMention, PostID: postID, CommentID: commentID})
+	}
+}
+
+//notifyPost notifies the users mentioned in a new post
+func (h *Handler) notifyPost(actorID, postID int, content string) {
+	h.notifyMentions(actorID, postID, 0, content, map[int]bool{actorID: true})
+}
+
+//notifyComment notifies the author of the comment replied to, or else of the post, about a new comment,
+//and the users it mentions. Nobody is notified twice for the same comment.
+func (h *Handler) notifyComment(actorID, postID, commentID int, parentID *int, content string) {
+	notified := map[int]bool{actorID: true}
+	if parentID != nil {
+		parent, err := h.Comments.Get(*parentID)
+		if err != nil {
+			log.Printf("Failed to load comment %d to notify its author: %v", *parentID, err)
+		} else if !notified[parent.UserID] {
+			notified[parent.UserID] = true
+			h.notify(store.Notification{UserID: parent.UserID, ActorID: actorID, Kind: store.NotifyReply, PostID: postID, CommentID: commentID})
+		}
+	}
+
+	post, err := h.Posts.Get(postID)
+	if err != nil {
+		log.Printf("Failed to load post %d to notify its author: %v", postID, err)
+	} else if !notified[post.UserID] {
+		notified[post.UserID] = true
+		h.notify(store.Notification{UserID: post.UserID, ActorID: actorID, Kind: store.NotifyComment, PostID: postID, CommentID: commentID})
+	}
+
+	h.notifyMentions(actorID, postID, commentID, content, notified)
+}
+
+//notifyReaction notifies the author of a post, or of a comment when commentID is set, about a new reaction
+func (h *Handler) notifyReaction(actorID, authorID, postID, commentID int, liked bool) {
+	kind := store.NotifyLike
+	if !liked {
+		kind = store.NotifyDislike
+	}
+	h.notify(store.Notification{UserID: authorID, ActorID: actorID, Kind: kind, PostID: postID, CommentID: commentID})
+}
+
+//unreadNotifications counts the unread notifications of the user for the header, 0 when logged out
+func (h *Handler) unreadNotifications(userData auth.ContextUser) int {
+	if !userData.LoggedIn {
+		return 0
+	}
+	count, err := h.Notifications.CountUnread(userData.UserID)
+	if err != nil {
+		log.Println("Error counting notifications:", err)
+	}
+	return count
+}
+
+//NotificationsHandler lists the latest notifications of the user
+func (h *Handler) NotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
+
+	notifications, err := h.Notifications.ListByUser(userData.UserID, notificationsShown)
+	if err != nil {
+		log.Println("Error loading notifications:", err)
+		db.HandleError(w, http.StatusInternalServerError, "Error loading notifications")
+		return
+	}
+
+	db.RenderTemplate(w, "notifications", map[string]interface{}{
+		"Title":         "Notifications",
+		"LoggedIn":      userData.LoggedIn,
+		"Username":      userData.Username,
+		"Notifications": notifications,
+		"Unread":        h.unreadNotifications(userData),
+	})
+}
+
+//MarkNotificationReadHandler marks one notification of the user read
+func (h *Handler) MarkNotificationReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
+
+	notificationID, err := strconv.Atoi(r.FormValue("notification_id"))
+	if err != nil {
+		db.HandleError(w, http.StatusBadRequest, "Invalid notification id")
+		return
+	}
+	err = h.Notifications.MarkRead(notificationID, userData.UserID, time.Now())
+	if errors.Is(err, store.ErrNotFound) {
+		db.HandleError(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Failed to mark the notification read")
+		return
+	}
+	http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+}
+
+//MarkAllNotificationsReadHandler marks every notification of the user read
+func (h *Handler) MarkAllNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
+
+	if err := h.Notifications.MarkAllRead(userData.UserID, time.Now()); err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Failed to mark the notifications read")
+		return
+	}
+	http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+}
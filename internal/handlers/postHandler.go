@@ -1,22 +1,25 @@
 package handlers
 
 import (
-	"database/sql"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"forum/internal/auth"
+	"forum/internal/store"
+
 	db "forum/internal/database"
 )
 
-func AddPostHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) AddPostHandler(w http.ResponseWriter, r *http.Request) {
 	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
-	categories, err := getCategories()
+	categories, err := h.Categories.List()
 	if err != nil {
 		db.HandleError(w, http.StatusInternalServerError, "Error loading categories")
 		return
 	}
+	categories = selectableCategories(categories, nil)
 
 	if r.Method == http.MethodGet {
 		db.RenderTemplate(w, "add_post", map[string]interface{}{
@@ -30,206 +33,319 @@ func AddPostHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == http.MethodPost {
-		//starting the transaction
-		tx, err := db.DB.Begin()
-		if err != nil {
-			db.HandleError(w, http.StatusInternalServerError, "Internal server error")
-			return
-		}
-		defer tx.Rollback()
-
-		//insert post
 		Title := r.FormValue("title")
 		Content := r.FormValue("content")
-		categoryStrs := r.Form["categories"]
+		selectedCategories := parseCategoryIDs(r.Form["categories"])
 
-		var selectedCategories []int
-		for _, catID := range categoryStrs {
-			if id, err := strconv.Atoi(catID); err == nil {
-				selectedCategories = append(selectedCategories, id)
-			}
-		}
-
-		if strings.TrimSpace(Title) == "" || strings.TrimSpace(Content) == "" || len(selectedCategories) == 0 {
+		if msg := validatePost(Title, Content, selectedCategories, categories); msg != "" {
 			w.WriteHeader(http.StatusBadRequest)
 			db.RenderTemplate(w, "add_post", map[string]interface{}{
 				"Title":              "Add Post",
 				"Categories":         categories,
 				"LoggedIn":           userData.LoggedIn,
 				"Username":           userData.Username,
+				"PostTitle":          Title,
+				"PostContent":        Content,
 				"SelectedCategories": selectedCategories,
-				"Error":              "Please fill in all fields and select at least one category.",
+				"Error":              msg,
 			})
 			return
 		}
 
-		if len(Title) > 50 || len(Content) > 1000 {
-			w.WriteHeader(http.StatusBadRequest)
-			db.RenderTemplate(w, "add_post", map[string]interface{}{
-				"Title":              "Add Post",
-				"Categories":         categories,
-				"LoggedIn":           userData.LoggedIn,
-				"Username":           userData.Username,
-				"SelectedCategories": selectedCategories,
-				"Error":              "Title or content length exceeded. Title must be <= 50 characters and content <= 1000 characters.",
-			})
+		postID, err := h.Posts.Create(userData.UserID, Title, Content, selectedCategories)
+		if err != nil {
+			db.HandleError(w, http.StatusInternalServerError, "Failed to create post")
 			return
 		}
+		h.notifyPost(userData.UserID, postID, Content)
+		h.publishPost(postID)
 
-		result, err := tx.Exec("INSERT INTO posts (user_id, title, content) VALUES (?, ?, ?)",
-			userData.UserID, Title, Content)
+		http.Redirect(w, r, postURL(postID), http.StatusSeeOther)
+	} else {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+}
+
+//EditPostHandler lets the author of a post or a moderator change its title, content and categories
+func (h *Handler) EditPostHandler(w http.ResponseWriter, r *http.Request) {
+	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
+	categories, err := h.Categories.List()
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Error loading categories")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		postID, err := strconv.Atoi(r.URL.Query().Get("id"))
 		if err != nil {
-			db.HandleError(w, http.StatusInternalServerError, "Failed to create post")
+			db.HandleError(w, http.StatusBadRequest, "Invalid post id")
+			return
+		}
+
+		post, status, msg := h.checkPostOwner(postID, userData)
+		if status != 0 {
+			db.HandleError(w, status, msg)
+			return
+		}
+
+		selectedCategories, err := h.Posts.CategoryIDs(postID)
+		if err != nil {
+			db.HandleError(w, http.StatusInternalServerError, "Error loading categories")
 			return
 		}
+		categories = selectableCategories(categories, selectedCategories)
+
+		db.RenderTemplate(w, "add_post", map[string]interface{}{
+			"Title":              "Edit Post",
+			"Editing":            true,
+			"PostID":             postID,
+			"PostTitle":          post.Title,
+			"PostContent":        post.Content,
+			"Categories":         categories,
+			"LoggedIn":           userData.LoggedIn,
+			"Username":           userData.Username,
+			"SelectedCategories": selectedCategories,
+		})
+		return
+	}
 
-		postID, err := result.LastInsertId()
+	if r.Method == http.MethodPost {
+		postID, err := strconv.Atoi(r.FormValue("post_id"))
 		if err != nil {
-			db.HandleError(w, http.StatusInternalServerError, "Failed to get post id")
+			db.HandleError(w, http.StatusBadRequest, "Invalid post id")
 			return
 		}
 
-		//handling categories
-		for _, catID := range selectedCategories {
-			_, err = tx.Exec("INSERT INTO post_categories (post_id, category_id) VALUES (?, ?)",
-				postID, catID)
-			if err != nil {
-				db.HandleError(w, http.StatusInternalServerError, "Failed to associate categories")
-				return
-			}
+		Title := r.FormValue("title")
+		Content := r.FormValue("content")
+		selectedCategories := parseCategoryIDs(r.Form["categories"])
+
+		// the post may stay in the archived categories it is already in
+		currentCategories, err := h.Posts.CategoryIDs(postID)
+		if err != nil {
+			db.HandleError(w, http.StatusInternalServerError, "Error loading categories")
+			return
 		}
+		categories = selectableCategories(categories, currentCategories)
 
-		if err = tx.Commit(); err != nil {
-			db.HandleError(w, http.StatusInternalServerError, "Failed to complete post creation")
+		if msg := validatePost(Title, Content, selectedCategories, categories); msg != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			db.RenderTemplate(w, "add_post", map[string]interface{}{
+				"Title":              "Edit Post",
+				"Editing":            true,
+				"PostID":             postID,
+				"PostTitle":          Title,
+				"PostContent":        Content,
+				"Categories":         categories,
+				"LoggedIn":           userData.LoggedIn,
+				"Username":           userData.Username,
+				"SelectedCategories": selectedCategories,
+				"Error":              msg,
+			})
+			return
+		}
+
+		if _, status, msg := h.checkPostOwner(postID, userData); status != 0 {
+			db.HandleError(w, status, msg)
 			return
 		}
 
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		if err := h.Posts.Update(postID, Title, Content, selectedCategories); err != nil {
+			db.HandleError(w, http.StatusInternalServerError, "Failed to update post")
+			return
+		}
+
+		http.Redirect(w, r, postURL(postID), http.StatusSeeOther)
 	} else {
 		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
 		return
 	}
 }
 
-//like and dislike handlers
-func LikePostHandler(w http.ResponseWriter, r *http.Request) {
+//DeletePostHandler removes a post of the current user, or any post for moderators, comments and reactions go with it
+func (h *Handler) DeletePostHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
 	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
 
-	postID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	postID, err := strconv.Atoi(r.FormValue("post_id"))
 	if err != nil {
-		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		db.HandleError(w, http.StatusBadRequest, "Invalid post id")
 		return
 	}
 
-	//start transaction
-	tx, err := db.DB.Begin()
-	if err != nil {
-		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+	if _, status, msg := h.checkPostOwner(postID, userData); status != 0 {
+		db.HandleError(w, status, msg)
 		return
 	}
-	defer tx.Rollback()
 
-	//checking if there's already a reaction from user on post.
-	var currentReaction bool
-	err = tx.QueryRow(
-		"SELECT liked FROM post_reactions WHERE post_id = ? AND user_id = ?",
-		postID, userData.UserID,
-	).Scan(&currentReaction)
-
-	if err != nil && err != sql.ErrNoRows {
-		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+	if err := h.Posts.Delete(postID); err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Failed to delete post")
 		return
 	}
 
-	if err == sql.ErrNoRows {
-		//if no existing reaction: insert a new like.
-		_, err = tx.Exec(
-			"INSERT INTO post_reactions (post_id, user_id, liked) VALUES (?, ?, ?)",
-			postID, userData.UserID, true)
-		if err != nil {
-			db.HandleError(w, http.StatusInternalServerError, "Internal server error")
-			return
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+//checkPostOwner loads the post, returning an error status and message unless it exists and
+//belongs to the user or the user is a moderator
+func (h *Handler) checkPostOwner(postID int, user auth.ContextUser) (*store.Post, int, string) {
+	post, err := h.Posts.Get(postID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, http.StatusNotFound, "Post not found"
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Internal server error"
+	}
+	if post.UserID != user.UserID && !user.CanModerate() {
+		return nil, http.StatusForbidden, "You can only change your own posts"
+	}
+	return post, 0, ""
+}
+
+//validatePost checks the post form against the categories it can pick from, returning the
+//message to show when it is invalid
+func validatePost(title, content string, categories []int, selectable []store.Category) string {
+	if strings.TrimSpace(title) == "" || strings.TrimSpace(content) == "" || len(categories) == 0 {
+		return "Please fill in all fields and select at least one category."
+	}
+	if len(title) > 50 || len(content) > 1000 {
+		return "Title or content length exceeded. Title must be <= 50 characters and content <= 1000 characters."
+	}
+	for _, id := range categories {
+		found := false
+		for _, cat := range selectable {
+			found = found || cat.ID == id
 		}
-	} else {
-		if currentReaction {
-			//if there's already a like, delete it.
-			_, err = tx.Exec("DELETE FROM post_reactions WHERE post_id = ? AND user_id = ?", postID, userData.UserID)
-			if err != nil {
-				db.HandleError(w, http.StatusInternalServerError, "Internal server error")
-				return
-			}
-		} else {
-			//if current reaction is dislike, change to like.
-			_, err = tx.Exec("UPDATE post_reactions SET liked = ? WHERE post_id = ? AND user_id = ?", true, postID, userData.UserID)
-			if err != nil {
-				db.HandleError(w, http.StatusInternalServerError, "Internal server error")
-				return
-			}
+		if !found {
+			return "Please select categories from the list."
 		}
 	}
+	return ""
+}
 
-	if err = tx.Commit(); err != nil {
-		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
-		return
+//selectableCategories keeps the categories a post can be filed under, the ones not archived
+//and the archived ones it is already in
+func selectableCategories(categories []store.Category, current []int) []store.Category {
+	var selectable []store.Category
+	for _, cat := range categories {
+		keep := !cat.Archived
+		for _, id := range current {
+			keep = keep || cat.ID == id
+		}
+		if keep {
+			selectable = append(selectable, cat)
+		}
 	}
+	return selectable
+}
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+//parseCategoryIDs converts the submitted category values, ignoring invalid ones
+func parseCategoryIDs(values []string) []int {
+	var ids []int
+	for _, catID := range values {
+		if id, err := strconv.Atoi(catID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
 }
 
-func DislikePostHandler(w http.ResponseWriter, r *http.Request) {
-	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
+//PostHandler renders a single post with its comments at /post?id=N
+func (h *Handler) PostHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+
+	userData, _ := r.Context().Value(auth.UserKey).(auth.ContextUser)
 
 	postID, err := strconv.Atoi(r.URL.Query().Get("id"))
 	if err != nil {
-		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		db.HandleError(w, http.StatusBadRequest, "Invalid post id")
 		return
 	}
 
-	//begin transaction
-	tx, err := db.DB.Begin()
-	if err != nil {
-		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+	post, status, msg := h.getVisiblePost(postID, userData)
+	if status != 0 {
+		db.HandleError(w, status, msg)
 		return
 	}
-	defer tx.Rollback()
 
-	//check if there's already a reaction from user on post.
-	var currentReaction bool
-	err = tx.QueryRow("SELECT liked FROM post_reactions WHERE post_id = ? AND user_id = ?", postID, userData.UserID).Scan(&currentReaction)
-	if err != nil && err != sql.ErrNoRows {
-		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
+	posts := []store.Post{*post}
+	if err := h.loadComments(posts, userData.UserID); err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Error loading comments")
 		return
 	}
 
-	if err == sql.ErrNoRows {
-		//if no existing reaction add a new dislike.
-		_, err = tx.Exec("INSERT INTO post_reactions (post_id, user_id, liked) VALUES (?, ?, ?)", postID, userData.UserID, false)
-		if err != nil {
-			db.HandleError(w, http.StatusInternalServerError, "Internal server error")
-			return
-		}
-	} else {
-		if !currentReaction {
-			//if current reaction is dislike delete it.
-			_, err = tx.Exec("DELETE FROM post_reactions WHERE post_id = ? AND user_id = ?", postID, userData.UserID)
-			if err != nil {
-				db.HandleError(w, http.StatusInternalServerError, "Internal server error")
-				return
-			}
-		} else {
-			//if current reaction is like, change it to a dislike.
-			_, err = tx.Exec("UPDATE post_reactions SET liked = ? WHERE post_id = ? AND user_id = ?", false, postID, userData.UserID)
-			if err != nil {
-				db.HandleError(w, http.StatusInternalServerError, "Internal server error")
-				return
-			}
-		}
+	db.RenderTemplate(w, "post", map[string]interface{}{
+		"Title":       post.Title,
+		"LoggedIn":    userData.LoggedIn,
+		"Username":    userData.Username,
+		"UserID":      userData.UserID,
+		"CanModerate": userData.CanModerate(),
+		"Post":        posts[0],
+	})
+}
+
+//getVisiblePost loads a post the user can see, or returns an error status and message. A hidden post, or
+//one of a shadowbanned user, is only shown to its author and the moderators, to everyone else it is not found.
+func (h *Handler) getVisiblePost(postID int, user auth.ContextUser) (*store.Post, int, string) {
+	post, err := h.Posts.Get(postID)
+	if errors.Is(err, store.ErrNotFound) ||
+		(err == nil && (post.Hidden || post.Shadowbanned) && post.UserID != user.UserID && !user.CanModerate()) {
+		return nil, http.StatusNotFound, "Post not found"
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Error loading post"
+	}
+	return post, 0, ""
+}
+
+//postURL returns the permalink of a post, used as redirect target after actions on it
+func postURL(postID int) string {
+	return "/post?id=" + strconv.Itoa(postID)
+}
+
+//like and dislike handlers
+func (h *Handler) LikePostHandler(w http.ResponseWriter, r *http.Request) {
+	h.reactToPost(w, r, true)
+}
+
+func (h *Handler) DislikePostHandler(w http.ResponseWriter, r *http.Request) {
+	h.reactToPost(w, r, false)
+}
+
+func (h *Handler) reactToPost(w http.ResponseWriter, r *http.Request, liked bool) {
+	if r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
 	}
+	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
 
-	if err = tx.Commit(); err != nil {
+	postID, err := strconv.Atoi(r.FormValue("post_id"))
+	if err != nil {
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+	post, status, msg := h.getVisiblePost(postID, userData)
+	if status != 0 {
+		db.HandleError(w, status, msg)
+		return
+	}
+
+	added, err := h.Reactions.TogglePost(postID, userData.UserID, liked)
+	if err != nil {
 		db.HandleError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
+	if added {
+		h.notifyReaction(userData.UserID, post.UserID, postID, 0, liked)
+	}
+	h.publishReaction(postID, 0)
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	http.Redirect(w, r, postURL(postID), http.StatusSeeOther)
 }
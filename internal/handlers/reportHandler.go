@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"forum/internal/auth"
+	"forum/internal/store"
+
+	db "forum/internal/database"
+)
+
+// reportReason is a reason code of a report with the label shown for it
+type reportReason struct {
+	Code  string
+	Label string
+}
+
+// reportReasons are the reasons a report can give, in the order of the form
+var reportReasons = []reportReason{
+	{"spam", "Spam or advertising"},
+	{"harassment", "Harassment or bullying"},
+	{"hate", "Hate speech"},
+	{"off_topic", "Off topic"},
+	{"illegal", "Illegal content"},
+	{"other", "Something else"},
+}
+
+const maxReportDetails = 500
+
+//reasonLabels maps the reason codes to their labels for the templates
+func reasonLabels() map[string]string {
+	labels := make(map[string]string, len(reportReasons))
+	for _, reason := range reportReasons {
+		labels[reason.Code] = reason.Label
+	}
+	return labels
+}
+
+//reportedContent is a reported post or comment, one of them is set
+type reportedContent struct {
+	Post    *store.Post
+	Comment *store.Comment
+}
+
+//AuthorID returns the user who wrote the content
+func (c reportedContent) AuthorID() int {
+	if c.Post != nil {
+		return c.Post.UserID
+	}
+	return c.Comment.UserID
+}
+
+//PostID returns the post the content is, or is a comment of
+func (c reportedContent) PostID() int {
+	if c.Post != nil {
+		return c.Post.ID
+	}
+	return c.Comment.PostID
+}
+
+//loadReported loads the post or the comment with the given id, returning an error status and message
+//when it does not exist
+func (h *Handler) loadReported(postID, commentID int) (reportedContent, int, string) {
+	var content reportedContent
+	var err error
+	if postID != 0 {
+		content.Post, err = h.Posts.Get(postID)
+	} else {
+		content.Comment, err = h.Comments.Get(commentID)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		return content, http.StatusNotFound, "Content not found"
+	}
+	if err != nil {
+		return content, http.StatusInternalServerError, "Internal server error"
+	}
+	return content, 0, ""
+}
+
+//reportTarget reads the post_id or comment_id of the report form and loads the content, which
+//the user must be able to see and must not have written
+func (h *Handler) reportTarget(r *http.Request, user auth.ContextUser) (reportedContent, int, string) {
+	postID, _ := strconv.Atoi(r.FormValue("post_id"))
+	commentID, _ := strconv.Atoi(r.FormValue("comment_id"))
+	if (postID == 0) == (commentID == 0) {
+		return reportedContent{}, http.StatusBadRequest, "Invalid report"
+	}
+
+	content, status, msg := h.loadReported(postID, commentID)
+	if status != 0 {
+		return content, status, msg
+	}
+	if (content.Post != nil && (content.Post.Hidden || content.Post.Shadowbanned)) ||
+		(content.Comment != nil && (content.Comment.Hidden || content.Comment.Deleted)) {
+		return content, http.StatusNotFound, "Content not found"
+	}
+	if content.AuthorID() == user.UserID {
+		return content, http.StatusBadRequest, "You cannot report your own content"
+	}
+	return content, 0, ""
+}
+
+//ReportHandler shows the report form for a post or a comment and files the report for the moderators
+func (h *Handler) ReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+	userData := r.Context().Value(auth.UserKey).(auth.ContextUser)
+
+	content, status, msg := h.reportTarget(r, userData)
+	if status != 0 {
+		db.HandleError(w, status, msg)
+		return
+	}
+	data := map[string]interface{}{
+		"Title":    "Report",
+		"LoggedIn": userData.LoggedIn,
+		"Username": userData.Username,
+		"Content":  content,
+		"Reasons":  reportReasons,
+	}
+
+	if r.Method == http.MethodGet {
+		db.RenderTemplate(w, "report", data)
+		return
+	}
+
+	reason := r.FormValue("reason")
+	details := strings.TrimSpace(r.FormValue("details"))
+	data["Reason"], data["Details"] = reason, details
+	if _, ok := reasonLabels()[reason]; !ok {
+		data["Error"] = "Please pick a reason."
+	} else if len(details) > maxReportDetails {
+		data["Error"] = "Details must be at most 500 characters."
+	}
+	if data["Error"] != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		db.RenderTemplate(w, "report", data)
+		return
+	}
+
+	report := &store.Report{ReporterID: userData.UserID, Reason: reason, Details: details}
+	if content.Post != nil {
+		report.PostID = content.Post.ID
+	} else {
+		report.CommentID = content.Comment.ID
+	}
+	err := h.Reports.Create(report)
+	if err != nil && !errors.Is(err, store.ErrDuplicate) {
+		db.HandleError(w, http.StatusInternalServerError, "Failed to send report")
+		return
+	}
+
+	// a second report of the same content while the first is open changes nothing
+	data["Sent"] = true
+	db.RenderTemplate(w, "report", data)
+}
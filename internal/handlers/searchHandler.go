@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"forum/internal/auth"
+	"forum/internal/store"
+
+	db "forum/internal/database"
+)
+
+// searchResult is a search hit with its snippet ready for the template
+type searchResult struct {
+	store.SearchResult
+	Snippet template.HTML
+}
+
+// highlight escapes a search snippet, turning only its match markers into <mark> tags
+func highlight(snippet string) template.HTML {
+	snippet = template.HTMLEscapeString(snippet)
+	snippet = strings.NewReplacer(store.MarkStart, "<mark>", store.MarkEnd, "</mark>").Replace(snippet)
+	return template.HTML(snippet)
+}
+
+func (h *Handler) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		db.HandleError(w, http.StatusMethodNotAllowed, "Invalid method")
+		return
+	}
+
+	userData, _ := r.Context().Value(auth.UserKey).(auth.ContextUser)
+	q := r.URL.Query()
+
+	categories, err := h.Categories.List()
+	if err != nil {
+		db.HandleError(w, http.StatusInternalServerError, "Error loading categories")
+		return
+	}
+
+	filter := buildPostFilter(q, userData)
+	text := strings.TrimSpace(q.Get("q"))
+	results, err := h.Posts.Search(store.SearchQuery{
+		Text:   text,
+		Author: q.Get("author"),
+		Filter: filter,
+	})
+	if err != nil {
+		log.Println("Error searching posts:", err)
+		db.HandleError(w, http.StatusInternalServerError, "Error searching posts")
+		return
+	}
+
+	hits := make([]searchResult, len(results))
+	for i, res := range results {
+		hits[i] = searchResult{SearchResult: res, Snippet: highlight(res.Snippet)}
+	}
+
+	db.RenderTemplate(w, "search", map[string]interface{}{
+		"Title":              "Search",
+		"LoggedIn":           userData.LoggedIn,
+		"Username":           userData.Username,
+		"Query":              text,
+		"Author":             q.Get("author"),
+		"Results":            hits,
+		"FilterCategories":   categories,
+		"SelectedCategories": filter.CategoryIDs,
+	})
+}
@@ -0,0 +1,37 @@
+package mail
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileMailer writes the messages to a file instead of sending them, or to
+// stdout when Path is empty. It is meant for development and tests.
+type FileMailer struct {
+	From string
+	Path string
+
+	mu sync.Mutex
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out io.Writer = os.Stdout
+	if m.Path != "" {
+		f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	_, err := fmt.Fprintf(out, "From: %s\nTo: %s\nDate: %s\nSubject: %s\n\n%s\n\n",
+		m.From, msg.To, time.Now().Format(time.RFC1123Z), msg.Subject, msg.Body)
+	return err
+}
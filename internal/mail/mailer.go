@@ -0,0 +1,44 @@
+package mail
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+var (
+	transport = flag.String("mail", "log", "Mail transport: log (stdout), file or smtp")
+	mailFile  = flag.String("mail-file", "mail.log", "File the file transport appends messages to")
+	mailFrom  = flag.String("mail-from", "forum@localhost", "Sender address of the forum mails")
+	smtpAddr  = flag.String("smtp-addr", "localhost:587", "SMTP server host:port")
+	smtpUser  = flag.String("smtp-user", "", "SMTP username, no authentication when empty")
+	smtpPass  = flag.String("smtp-pass", "", "SMTP password, defaults to the SMTP_PASSWORD environment variable")
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends the forum mails, like password reset links
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// FromFlags builds the mailer selected by the -mail flag
+func FromFlags() (Mailer, error) {
+	switch *transport {
+	case "log":
+		return &FileMailer{From: *mailFrom}, nil
+	case "file":
+		return &FileMailer{From: *mailFrom, Path: *mailFile}, nil
+	case "smtp":
+		password := *smtpPass
+		if password == "" {
+			password = os.Getenv("SMTP_PASSWORD")
+		}
+		return &SMTPMailer{Addr: *smtpAddr, From: *mailFrom, Username: *smtpUser, Password: password}, nil
+	}
+	return nil, fmt.Errorf("unknown mail transport %q, expected log, file or smtp", *transport)
+}
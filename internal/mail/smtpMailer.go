@@ -0,0 +1,39 @@
+package mail
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPMailer sends plain text mails through an SMTP server, authenticating
+// with PLAIN when a username is set
+type SMTPMailer struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	// header injection guard, the recipient and subject come from user input
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return fmt.Errorf("invalid mail header")
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	body := strings.ReplaceAll(msg.Body, "\n", "\r\n")
+	data := fmt.Sprintf("From: %s\r\nTo: %s\r\nDate: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		m.From, msg.To, time.Now().Format(time.RFC1123Z), msg.Subject, body)
+	return smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, []byte(data))
+}
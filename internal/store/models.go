@@ -0,0 +1,328 @@
+package store
+
+import "time"
+
+// Roles of the users, each one can do what the ones before it can
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// RoleAtLeast tells whether role includes the permissions of min
+func RoleAtLeast(role, min string) bool {
+	return roleRank(role) >= roleRank(min)
+}
+
+// ValidRole tells whether role is one of the roles
+func ValidRole(role string) bool {
+	return roleRank(role) > 0
+}
+
+func roleRank(role string) int {
+	switch role {
+	case RoleUser:
+		return 1
+	case RoleModerator:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+// Scopes of the personal access tokens: read for GET requests, write for the
+// others and moderate for the moderation API on top of them
+const (
+	ScopeRead     = "read"
+	ScopeWrite    = "write"
+	ScopeModerate = "moderate"
+)
+
+// Kinds of bans: a suspension ends at its expiry, a ban is permanent and a
+// shadowban hides what the user writes from everyone else
+const (
+	BanSuspension = "suspension"
+	BanPermanent  = "ban"
+	BanShadow     = "shadowban"
+)
+
+// Kinds of notifications: a comment on a post of the user, a reply to their
+// comment, a reaction to their post or comment, or a mention of their @username
+const (
+	NotifyComment = "comment"
+	NotifyReply   = "reply"
+	NotifyLike    = "like"
+	NotifyDislike = "dislike"
+	NotifyMention = "mention"
+)
+
+type User struct {
+	ID              int
+	Username        string
+	Email           string
+	PasswordHash    string
+	CreatedAt       time.Time
+	EmailVerifiedAt *time.Time
+	Role            string
+}
+
+type Session struct {
+	ID            string
+	UserID        int
+	Username      string
+	EmailVerified bool
+	Role          string
+	CSRFToken     string
+	UserAgent     string
+	IP            string
+	Remember      bool
+	CreatedAt     time.Time
+	LastSeenAt    time.Time
+	ExpiresAt     time.Time
+}
+
+// Throttle counts the recent attempts of a rate limited key, a client IP or an account
+// Identity links an account at an OAuth provider to a user
+type Identity struct {
+	ID        int
+	UserID    int
+	Provider  string
+	Subject   string
+	Email     string
+	CreatedAt time.Time
+}
+
+// TOTP is the second factor of a user, EnabledAt is nil during enrollment
+type TOTP struct {
+	UserID    int
+	Secret    string
+	EnabledAt *time.Time
+	LastStep  int64
+}
+
+// PendingLogin is a login that passed the password and waits for the second factor
+type PendingLogin struct {
+	TokenHash string
+	UserID    int
+	Remember  bool
+	ExpiresAt time.Time
+}
+
+type Throttle struct {
+	Key           string
+	Attempts      int
+	LastAttemptAt time.Time
+	LockedUntil   *time.Time
+}
+
+type AuthAttempt struct {
+	Kind   string // login or register
+	IP     string
+	Email  string
+	Reason string
+}
+
+// Category is listed in tree order, Depth is how many parents it has
+type Category struct {
+	ID          int
+	Name        string
+	Description string
+	ParentID    int // 0 for a top level category
+	Position    int
+	Archived    bool
+	Depth       int
+	PostCount   int
+}
+
+type Comment struct {
+	ID        int
+	PostID    int
+	UserID    int
+	Username  string
+	Content   string
+	CreatedAt time.Time
+	UpdatedAt *time.Time
+	Deleted   bool
+	Hidden    bool // hidden by a moderator
+	Likes     int
+	Dislikes  int
+	ParentID  *int
+	Depth     int
+	Replies   []Comment
+}
+
+type Post struct {
+	ID           int
+	UserID       int
+	Username     string
+	Title        string
+	Content      string
+	CreatedAt    time.Time
+	UpdatedAt    *time.Time
+	Likes        int
+	Dislikes     int
+	CommentCount int
+	Categories   []string
+	Hidden       bool // hidden by a moderator
+	Shadowbanned bool // written by a shadowbanned user
+	Comments     []Comment
+}
+
+// PostFilter selects the posts of the feed, zero values mean no filtering
+type PostFilter struct {
+	CategoryIDs []int
+	AuthorID    int // posts created by this user
+	LikedBy     int // posts liked by this user
+	ViewerID    int // the user reading, who sees their own posts when shadowbanned
+	Sort        string
+	Limit       int
+	Offset      int
+}
+
+// SearchQuery is a full-text search narrowed by the feed filters and an author name
+type SearchQuery struct {
+	Text   string
+	Author string
+	Filter PostFilter
+}
+
+// SearchResult is the best match of a post, Snippet has the matched terms
+// wrapped in MarkStart and MarkEnd
+type SearchResult struct {
+	PostID    int
+	Title     string
+	Username  string
+	CreatedAt time.Time
+	InComment bool
+	Snippet   string
+}
+
+const (
+	MarkStart = "\x02"
+	MarkEnd   = "\x03"
+)
+
+// Report flags a post or a comment for the moderators, one of PostID and
+// CommentID is set
+type Report struct {
+	ID           int
+	ReporterID   int
+	ReporterName string
+	PostID       int
+	CommentID    int
+	Reason       string
+	Details      string
+	CreatedAt    time.Time
+	ResolvedAt   *time.Time
+	Resolution   string
+}
+
+// ModerationEntry is one action of a moderator, the ids are 0 when they do not apply
+type ModerationEntry struct {
+	ID            int
+	ModeratorID   int
+	ModeratorName string
+	Action        string
+	ReportID      int
+	PostID        int
+	CommentID     int
+	TargetUserID  int
+	TargetName    string
+	Note          string
+	CreatedAt     time.Time
+}
+
+// ModerationAction is what a moderator does about the post or the comment of Entry
+type ModerationAction struct {
+	Entry ModerationEntry
+	// Hide hides the content when true, shows it again when false
+	Hide *bool
+	// Delete deletes a post with its comments, or leaves a placeholder for a comment
+	Delete bool
+	// Warning is given to the author
+	Warning *Warning
+	// ResolveAt closes the open reports on the content, with the action as resolution
+	ResolveAt *time.Time
+}
+
+// Warning is given to a user by a moderator
+type Warning struct {
+	ID          int
+	UserID      int
+	ModeratorID int
+	Reason      string
+	CreatedAt   time.Time
+}
+
+// Ban is a sanction on a user, ExpiresAt is only set for suspensions
+type Ban struct {
+	ID          int
+	UserID      int
+	Username    string
+	ModeratorID int
+	Kind        string
+	Reason      string
+	CreatedAt   time.Time
+	ExpiresAt   *time.Time
+	LiftedAt    *time.Time
+}
+
+// APIToken is a personal access token of a user for the JSON API. The user
+// fields are filled in by Get for the request it authenticates.
+type APIToken struct {
+	ID            int
+	UserID        int
+	Username      string
+	EmailVerified bool
+	Role          string
+	Name          string
+	Scopes        []string
+	CreatedAt     time.Time
+	ExpiresAt     *time.Time
+	LastUsedAt    *time.Time
+}
+
+// Notification tells a user what someone else did, CommentID is 0 when it is about
+// the post itself
+type Notification struct {
+	ID        int
+	UserID    int
+	ActorID   int
+	ActorName string
+	Kind      string
+	PostID    int
+	PostTitle string
+	CommentID int
+	CreatedAt time.Time
+	ReadAt    *time.Time
+}
+
+// Conversation is a private conversation as one of its two members sees it,
+// with the other member and the latest message
+type Conversation struct {
+	ID        int
+	OtherID   int
+	OtherName string
+	CreatedAt time.Time
+	LastBody  string
+	LastAt    *time.Time
+	Unread    int
+}
+
+// Message is a private message in a conversation
+type Message struct {
+	ID             int
+	ConversationID int
+	SenderID       int
+	SenderName     string
+	Body           string
+	CreatedAt      time.Time
+}
+
+// Block is a user blocked by someone, as listed to them
+type Block struct {
+	UserID    int
+	Username  string
+	CreatedAt time.Time
+}
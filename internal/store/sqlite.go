@@ -0,0 +1,49 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// NewSQLite returns the stores backed by an SQLite database, fullText tells
+// whether the FTS5 search index is available
+func NewSQLite(conn *sql.DB, fullText bool) *Stores {
+	return &Stores{
+		Users:         &sqliteUsers{db: conn},
+		Sessions:      &sqliteSessions{db: conn},
+		Posts:         &sqlitePosts{db: conn, fullText: fullText},
+		Comments:      &sqliteComments{db: conn},
+		Reactions:     &sqliteReactions{db: conn},
+		Categories:    &sqliteCategories{db: conn},
+		Throttles:     &sqliteThrottles{db: conn},
+		Resets:        &sqliteResets{db: conn},
+		Verifications: &sqliteVerifications{db: conn},
+		TwoFactor:     &sqliteTwoFactor{db: conn},
+		Identities:    &sqliteIdentities{db: conn},
+		PendingLogins: &sqlitePendingLogins{db: conn},
+		Reports:       &sqliteReports{db: conn},
+		Moderation:    &sqliteModeration{db: conn},
+		Bans:          &sqliteBans{db: conn},
+		APITokens:     &sqliteAPITokens{db: conn},
+		Notifications: &sqliteNotifications{db: conn},
+		Messages:      &sqliteMessages{db: conn},
+		Blocks:        &sqliteBlocks{db: conn},
+	}
+}
+
+// execer runs statements on the database or in a transaction, for the writes the
+// stores share
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// placeholders builds "?, ?, ?" for an IN clause with its arguments
+func placeholders(ids []int) (string, []interface{}) {
+	marks := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		marks[i] = "?"
+		args[i] = id
+	}
+	return strings.Join(marks, ", "), args
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+type sqliteAPITokens struct {
+	db *sql.DB
+}
+
+const apiTokensSelect = `
+	SELECT t.id, t.user_id, u.username, u.email_verified_at IS NOT NULL, u.role, t.name, t.scopes, t.created_at, t.expires_at, t.last_used_at
+	FROM api_tokens t
+	JOIN users u ON u.id = t.user_id
+	WHERE t.revoked_at IS NULL
+	`
+
+func scanAPIToken(row interface{ Scan(...interface{}) error }, t *APIToken) error {
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.UserID, &t.Username, &t.EmailVerified, &t.Role, &t.Name, &scopes,
+		&t.CreatedAt, &expiresAt, &lastUsedAt); err != nil {
+		return err
+	}
+	t.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		t.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+	return nil
+}
+
+func (s *sqliteAPITokens) Create(t *APIToken, tokenHash string) error {
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now()
+	}
+	result, err := s.db.Exec("INSERT INTO api_tokens (user_id, name, token_hash, scopes, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		t.UserID, t.Name, tokenHash, strings.Join(t.Scopes, " "), t.CreatedAt, t.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	t.ID = int(id)
+	return err
+}
+
+func (s *sqliteAPITokens) Get(tokenHash string, now time.Time) (*APIToken, error) {
+	var t APIToken
+	err := scanAPIToken(s.db.QueryRow(apiTokensSelect+" AND t.token_hash = ? AND (t.expires_at IS NULL OR t.expires_at > ?)",
+		tokenHash, now), &t)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *sqliteAPITokens) ListByUser(userID int) ([]APIToken, error) {
+	rows, err := s.db.Query(apiTokensSelect+" AND t.user_id = ? ORDER BY t.created_at DESC, t.id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		var t APIToken
+		if err := scanAPIToken(rows, &t); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *sqliteAPITokens) Touch(id int, at time.Time) error {
+	_, err := s.db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", at, id)
+	return err
+}
+
+func (s *sqliteAPITokens) Revoke(id, userID int, at time.Time) error {
+	result, err := s.db.Exec("UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL", at, id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
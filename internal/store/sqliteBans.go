@@ -0,0 +1,105 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+type sqliteBans struct {
+	db *sql.DB
+}
+
+// shadowbannedUsers selects the users under a shadowban that was not lifted
+const shadowbannedUsers = `SELECT user_id FROM user_bans WHERE kind = 'shadowban' AND lifted_at IS NULL`
+
+const bansSelect = `
+	SELECT b.id, b.user_id, u.username, COALESCE(b.moderator_id, 0), b.kind, b.reason, b.created_at, b.expires_at, b.lifted_at
+	FROM user_bans b
+	JOIN users u ON u.id = b.user_id
+	WHERE b.lifted_at IS NULL AND (b.expires_at IS NULL OR b.expires_at > ?)
+	`
+
+func (s *sqliteBans) Create(b *Ban, entry *ModerationEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO user_bans (user_id, moderator_id, kind, reason, expires_at) VALUES (?, ?, ?, ?, ?)",
+		b.UserID, nullableID(b.ModeratorID), b.Kind, b.Reason, b.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err := logEntry(tx, entry); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	b.ID = int(id)
+	return nil
+}
+
+func (s *sqliteBans) Active(userID int, now time.Time) ([]Ban, error) {
+	return s.list(bansSelect+" AND b.user_id = ? ORDER BY b.created_at DESC, b.id DESC", now, userID)
+}
+
+func (s *sqliteBans) ListActive(now time.Time) ([]Ban, error) {
+	return s.list(bansSelect+" ORDER BY b.created_at DESC, b.id DESC", now)
+}
+
+func (s *sqliteBans) list(query string, args ...interface{}) ([]Ban, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []Ban
+	for rows.Next() {
+		var b Ban
+		var expiresAt, liftedAt sql.NullTime
+		if err := rows.Scan(&b.ID, &b.UserID, &b.Username, &b.ModeratorID, &b.Kind, &b.Reason,
+			&b.CreatedAt, &expiresAt, &liftedAt); err != nil {
+			return nil, err
+		}
+		if expiresAt.Valid {
+			b.ExpiresAt = &expiresAt.Time
+		}
+		if liftedAt.Valid {
+			b.LiftedAt = &liftedAt.Time
+		}
+		bans = append(bans, b)
+	}
+	return bans, rows.Err()
+}
+
+func (s *sqliteBans) Lift(id int, at time.Time, entry *ModerationEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE user_bans SET lifted_at = ?
+		WHERE id = ? AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)`, at, id, at)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	if err := logEntry(tx, entry); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
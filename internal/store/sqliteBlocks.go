@@ -0,0 +1,56 @@
+package store
+
+import "database/sql"
+
+type sqliteBlocks struct {
+	db *sql.DB
+}
+
+func (s *sqliteBlocks) Block(blockerID, blockedID int) error {
+	_, err := s.db.Exec("INSERT OR IGNORE INTO user_blocks (blocker_id, blocked_id) VALUES (?, ?)", blockerID, blockedID)
+	return err
+}
+
+func (s *sqliteBlocks) Unblock(blockerID, blockedID int) error {
+	result, err := s.db.Exec("DELETE FROM user_blocks WHERE blocker_id = ? AND blocked_id = ?", blockerID, blockedID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteBlocks) ListBlocked(userID int) ([]Block, error) {
+	rows, err := s.db.Query(`SELECT u.id, u.username, b.created_at FROM user_blocks b
+		JOIN users u ON u.id = b.blocked_id
+		WHERE b.blocker_id = ?
+		ORDER BY u.username`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocks []Block
+	for rows.Next() {
+		var b Block
+		if err := rows.Scan(&b.UserID, &b.Username, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, rows.Err()
+}
+
+func (s *sqliteBlocks) Between(userID, otherID int) (bool, error) {
+	var blocked bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM user_blocks
+		WHERE (blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?))`,
+		userID, otherID, otherID, userID).Scan(&blocked)
+	return blocked, err
+}
@@ -0,0 +1,228 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+type sqliteCategories struct {
+	db *sql.DB
+}
+
+// isUnique tells whether err is the violation of a UNIQUE constraint
+func isUnique(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+const categoriesSelect = `
+	SELECT c.id, c.name, COALESCE(c.description, ''), COALESCE(c.parent_id, 0), c.position,
+		c.archived_at IS NOT NULL, (SELECT COUNT(*) FROM post_categories pc WHERE pc.category_id = c.id)
+	FROM categories c
+	`
+
+func scanCategory(row interface{ Scan(...interface{}) error }, c *Category) error {
+	return row.Scan(&c.ID, &c.Name, &c.Description, &c.ParentID, &c.Position, &c.Archived, &c.PostCount)
+}
+
+func (s *sqliteCategories) List() ([]Category, error) {
+	rows, err := s.db.Query(categoriesSelect + " ORDER BY c.position, c.name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []Category
+	for rows.Next() {
+		var c Category
+		if err := scanCategory(rows, &c); err != nil {
+			return nil, err
+		}
+		all = append(all, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return categoryTree(all), nil
+}
+
+// categoryTree orders the categories depth first, each one followed by its
+// children. A category whose parent is missing is listed at the top level.
+func categoryTree(all []Category) []Category {
+	ids := make(map[int]bool, len(all))
+	for _, c := range all {
+		ids[c.ID] = true
+	}
+	children := make(map[int][]Category)
+	for _, c := range all {
+		parent := c.ParentID
+		if !ids[parent] {
+			parent = 0
+		}
+		children[parent] = append(children[parent], c)
+	}
+
+	tree := make([]Category, 0, len(all))
+	var walk func(parent, depth int)
+	walk = func(parent, depth int) {
+		for _, c := range children[parent] {
+			c.Depth = depth
+			tree = append(tree, c)
+			walk(c.ID, depth+1)
+		}
+	}
+	walk(0, 0)
+	return tree
+}
+
+func (s *sqliteCategories) Get(id int) (*Category, error) {
+	var c Category
+	err := scanCategory(s.db.QueryRow(categoriesSelect+" WHERE c.id = ?", id), &c)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// nullableParent stores the top level as NULL so the foreign key holds
+func nullableParent(parentID int) interface{} {
+	if parentID == 0 {
+		return nil
+	}
+	return parentID
+}
+
+func (s *sqliteCategories) Create(name, description string, parentID int) (int, error) {
+	result, err := s.db.Exec(`INSERT INTO categories (name, description, parent_id, position)
+		VALUES (?, ?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM categories))`,
+		name, description, nullableParent(parentID))
+	if isUnique(err) {
+		return 0, ErrDuplicate
+	}
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+func (s *sqliteCategories) Update(id int, name, description string, parentID int) error {
+	// a category that changes parent goes after its new siblings
+	result, err := s.db.Exec(`UPDATE categories SET name = ?, description = ?,
+		position = CASE WHEN COALESCE(parent_id, 0) = ? THEN position
+			ELSE (SELECT COALESCE(MAX(position), 0) + 1 FROM categories) END,
+		parent_id = ?
+		WHERE id = ?`, name, description, parentID, nullableParent(parentID), id)
+	if isUnique(err) {
+		return ErrDuplicate
+	}
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteCategories) Move(id int, up bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var parentID, position int
+	err = tx.QueryRow("SELECT COALESCE(parent_id, 0), position FROM categories WHERE id = ?", id).Scan(&parentID, &position)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// positions are unique, every category gets the highest one when it is
+	// created or moved under another parent
+	query := `SELECT id, position FROM categories WHERE COALESCE(parent_id, 0) = ? AND position > ?
+		ORDER BY position LIMIT 1`
+	if up {
+		query = `SELECT id, position FROM categories WHERE COALESCE(parent_id, 0) = ? AND position < ?
+		ORDER BY position DESC LIMIT 1`
+	}
+	var siblingID, siblingPosition int
+	err = tx.QueryRow(query, parentID, position).Scan(&siblingID, &siblingPosition)
+	if err == sql.ErrNoRows {
+		// already first or last
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE categories SET position = ? WHERE id = ?", siblingPosition, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE categories SET position = ? WHERE id = ?", position, siblingID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteCategories) SetArchived(id int, archived bool) error {
+	var archivedAt interface{}
+	if archived {
+		archivedAt = time.Now()
+	}
+	result, err := s.db.Exec("UPDATE categories SET archived_at = ? WHERE id = ?", archivedAt, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteCategories) Merge(fromID, intoID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// posts already in both keep a single row
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO post_categories (post_id, category_id)
+		SELECT post_id, ? FROM post_categories WHERE category_id = ?`, intoID, fromID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM post_categories WHERE category_id = ?", fromID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE categories SET parent_id = ? WHERE parent_id = ?", intoID, fromID); err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM categories WHERE id = ?", fromID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
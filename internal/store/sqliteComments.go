@@ -0,0 +1,104 @@
+package store
+
+import (
+	"database/sql"
+	"log"
+)
+
+type sqliteComments struct {
+	db *sql.DB
+}
+
+const commentsSelect = `
+	SELECT cm.id, cm.post_id, cm.user_id, u.username, cm.content, cm.created_at, cm.updated_at, cm.deleted, cm.hidden_at IS NOT NULL, cm.parent_comment_id,
+	(SELECT COUNT(*) FROM comment_reactions cr WHERE cr.comment_id = cm.id AND cr.liked = 1) AS likes,
+	(SELECT COUNT(*) FROM comment_reactions cr WHERE cr.comment_id = cm.id AND cr.liked = 0) AS dislikes
+	FROM comments cm
+	JOIN users u ON cm.user_id = u.id
+	`
+
+func scanComment(row interface{ Scan(...interface{}) error }, c *Comment) error {
+	return row.Scan(&c.ID, &c.PostID, &c.UserID, &c.Username, &c.Content, &c.CreatedAt, &c.UpdatedAt, &c.Deleted, &c.Hidden, &c.ParentID, &c.Likes, &c.Dislikes)
+}
+
+func (s *sqliteComments) ListByPosts(postIDs []int, viewerID int) (map[int][]Comment, error) {
+	commentsMap := make(map[int][]Comment)
+	if len(postIDs) == 0 {
+		return commentsMap, nil
+	}
+
+	marks, args := placeholders(postIDs)
+	args = append(args, viewerID)
+	rows, err := s.db.Query(commentsSelect+" WHERE cm.post_id IN ("+marks+")"+
+		" AND (cm.user_id = ? OR cm.user_id NOT IN ("+shadowbannedUsers+"))"+
+		" ORDER BY cm.created_at ASC, cm.id ASC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Comment
+		if err := scanComment(rows, &c); err != nil {
+			// log error and continue with other comments.
+			log.Println("Error at rows scan comment", err)
+			continue
+		}
+		commentsMap[c.PostID] = append(commentsMap[c.PostID], c)
+	}
+	return commentsMap, rows.Err()
+}
+
+func (s *sqliteComments) Get(id int) (*Comment, error) {
+	var c Comment
+	err := scanComment(s.db.QueryRow(commentsSelect+" WHERE cm.id = ?", id), &c)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *sqliteComments) Create(postID, userID int, content string, parentID *int) (int, error) {
+	result, err := s.db.Exec("INSERT INTO comments (post_id, user_id, content, parent_comment_id) VALUES (?, ?, ?, ?)",
+		postID, userID, content, parentID)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+func (s *sqliteComments) Update(id int, content string) error {
+	_, err := s.db.Exec("UPDATE comments SET content = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted = 0", content, id)
+	return err
+}
+
+func (s *sqliteComments) SoftDelete(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := softDeleteComment(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// softDeleteComment keeps the row for the placeholder, its content and reactions go
+func softDeleteComment(tx *sql.Tx, id int) error {
+	_, err := tx.Exec("UPDATE comments SET content = '', deleted = 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM comment_reactions WHERE comment_id = ?", id)
+	return err
+}
+
+func (s *sqliteComments) SetHidden(id int, hidden bool) error {
+	return setHidden(s.db, "comments", id, hidden)
+}
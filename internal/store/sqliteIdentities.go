@@ -0,0 +1,57 @@
+package store
+
+import (
+	"database/sql"
+)
+
+type sqliteIdentities struct {
+	db *sql.DB
+}
+
+func (s *sqliteIdentities) Get(provider, subject string) (*Identity, error) {
+	i := &Identity{Provider: provider, Subject: subject}
+	err := s.db.QueryRow("SELECT id, user_id, email, created_at FROM user_identities WHERE provider = ? AND subject = ?",
+		provider, subject).Scan(&i.ID, &i.UserID, &i.Email, &i.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+func (s *sqliteIdentities) ListByUser(userID int) ([]Identity, error) {
+	rows, err := s.db.Query(`SELECT id, provider, subject, email, created_at FROM user_identities
+		WHERE user_id = ? ORDER BY provider`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []Identity
+	for rows.Next() {
+		i := Identity{UserID: userID}
+		if err := rows.Scan(&i.ID, &i.Provider, &i.Subject, &i.Email, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
+
+func (s *sqliteIdentities) Create(i *Identity) error {
+	result, err := s.db.Exec("INSERT INTO user_identities (user_id, provider, subject, email) VALUES (?, ?, ?, ?)",
+		i.UserID, i.Provider, i.Subject, i.Email)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	i.ID = int(id)
+	return err
+}
+
+func (s *sqliteIdentities) Delete(userID int, provider string) error {
+	_, err := s.db.Exec("DELETE FROM user_identities WHERE user_id = ? AND provider = ?", userID, provider)
+	return err
+}
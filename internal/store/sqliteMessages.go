@@ -0,0 +1,167 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+type sqliteMessages struct {
+	db *sql.DB
+}
+
+// visibleMessage is the condition on a message m for the viewer in the first argument,
+// who sees their own messages when shadowbanned
+const visibleMessage = `(m.sender_id = ? OR m.sender_id NOT IN (` + shadowbannedUsers + `))`
+
+// conversationsSelect selects the conversations of the member in the first argument,
+// with the other member, the latest message they see and how many are unread
+const conversationsSelect = `
+	SELECT c.id, o.id, o.username, c.created_at, COALESCE(lm.body, ''), lm.created_at,
+		(SELECT COUNT(*) FROM messages m
+			WHERE m.conversation_id = c.id AND m.id > me.last_read_id AND m.sender_id != me.user_id
+			AND m.sender_id NOT IN (` + shadowbannedUsers + `))
+	FROM conversations c
+	JOIN conversation_members me ON me.conversation_id = c.id AND me.user_id = ?
+	JOIN users o ON o.id = CASE WHEN c.user_a = me.user_id THEN c.user_b ELSE c.user_a END
+	LEFT JOIN messages lm ON lm.id = (
+		SELECT MAX(m.id) FROM messages m WHERE m.conversation_id = c.id AND ` + visibleMessage + `)
+	`
+
+func scanConversation(row interface{ Scan(...interface{}) error }, c *Conversation) error {
+	var lastAt sql.NullTime
+	if err := row.Scan(&c.ID, &c.OtherID, &c.OtherName, &c.CreatedAt, &c.LastBody, &lastAt, &c.Unread); err != nil {
+		return err
+	}
+	if lastAt.Valid {
+		c.LastAt = &lastAt.Time
+	}
+	return nil
+}
+
+func (s *sqliteMessages) Open(userID, otherID int) (*Conversation, error) {
+	a, b := userID, otherID
+	if a > b {
+		a, b = b, a
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("INSERT OR IGNORE INTO conversations (user_a, user_b) VALUES (?, ?)", a, b); err != nil {
+		return nil, err
+	}
+	var id int
+	if err := tx.QueryRow("SELECT id FROM conversations WHERE user_a = ? AND user_b = ?", a, b).Scan(&id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("INSERT OR IGNORE INTO conversation_members (conversation_id, user_id) VALUES (?, ?), (?, ?)",
+		id, a, id, b); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.Get(id, userID)
+}
+
+func (s *sqliteMessages) Get(id, userID int) (*Conversation, error) {
+	var c Conversation
+	err := scanConversation(s.db.QueryRow(conversationsSelect+" WHERE c.id = ?", userID, userID, id), &c)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *sqliteMessages) ListByUser(userID int) ([]Conversation, error) {
+	rows, err := s.db.Query(conversationsSelect+" WHERE lm.id IS NOT NULL ORDER BY lm.id DESC", userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversations []Conversation
+	for rows.Next() {
+		var c Conversation
+		if err := scanConversation(rows, &c); err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, c)
+	}
+	return conversations, rows.Err()
+}
+
+func (s *sqliteMessages) ListMessages(conversationID, userID, limit int) ([]Message, error) {
+	rows, err := s.db.Query(`
+		SELECT * FROM (
+			SELECT m.id, m.conversation_id, m.sender_id, u.username, m.body, m.created_at
+			FROM messages m
+			JOIN users u ON u.id = m.sender_id
+			WHERE m.conversation_id = ? AND `+visibleMessage+`
+			ORDER BY m.id DESC
+			LIMIT ?
+		) ORDER BY id`, conversationID, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.SenderID, &m.SenderName, &m.Body, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+func (s *sqliteMessages) Send(m *Message) error {
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now()
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO messages (conversation_id, sender_id, body, created_at) VALUES (?, ?, ?, ?)",
+		m.ConversationID, m.SenderID, m.Body, m.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	m.ID = int(id)
+	// the sender has read their own message
+	if _, err := tx.Exec("UPDATE conversation_members SET last_read_id = ? WHERE conversation_id = ? AND user_id = ?",
+		m.ID, m.ConversationID, m.SenderID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteMessages) MarkRead(conversationID, userID, messageID int) error {
+	_, err := s.db.Exec("UPDATE conversation_members SET last_read_id = MAX(last_read_id, ?) WHERE conversation_id = ? AND user_id = ?",
+		messageID, conversationID, userID)
+	return err
+}
+
+func (s *sqliteMessages) CountUnread(userID int) (int, error) {
+	var count int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM messages m
+		JOIN conversation_members me ON me.conversation_id = m.conversation_id AND me.user_id = ?
+		WHERE m.id > me.last_read_id AND m.sender_id != me.user_id AND m.sender_id NOT IN (`+shadowbannedUsers+`)`,
+		userID).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"database/sql"
+)
+
+type sqliteModeration struct {
+	db *sql.DB
+}
+
+// logEntry writes an entry to the moderation log
+func logEntry(db execer, e *ModerationEntry) error {
+	result, err := db.Exec(`INSERT INTO moderation_log (moderator_id, action, report_id, post_id, comment_id, target_user_id, note)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		e.ModeratorID, e.Action, nullableID(e.ReportID), nullableID(e.PostID), nullableID(e.CommentID),
+		nullableID(e.TargetUserID), e.Note)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	e.ID = int(id)
+	return err
+}
+
+func (s *sqliteModeration) Apply(a *ModerationAction) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	e := &a.Entry
+	if a.ResolveAt != nil {
+		// before a delete, which takes the reports of a post with it
+		if err := resolveReports(tx, e.PostID, e.CommentID, e.ModeratorID, e.Action, *a.ResolveAt); err != nil {
+			return err
+		}
+	}
+	if a.Hide != nil {
+		table, id := "posts", e.PostID
+		if e.CommentID != 0 {
+			table, id = "comments", e.CommentID
+		}
+		if err := setHidden(tx, table, id, *a.Hide); err != nil {
+			return err
+		}
+	}
+	if a.Delete {
+		if e.CommentID != 0 {
+			err = softDeleteComment(tx, e.CommentID)
+		} else {
+			err = deletePost(tx, e.PostID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if a.Warning != nil {
+		if err := warn(tx, a.Warning); err != nil {
+			return err
+		}
+	}
+	if err := logEntry(tx, e); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteModeration) ListLog(limit int) ([]ModerationEntry, error) {
+	rows, err := s.db.Query(`
+		SELECT l.id, l.moderator_id, COALESCE(m.username, ''), l.action, COALESCE(l.report_id, 0),
+			COALESCE(l.post_id, 0), COALESCE(l.comment_id, 0), COALESCE(l.target_user_id, 0),
+			COALESCE(t.username, ''), l.note, l.created_at
+		FROM moderation_log l
+		LEFT JOIN users m ON m.id = l.moderator_id
+		LEFT JOIN users t ON t.id = l.target_user_id
+		ORDER BY l.created_at DESC, l.id DESC
+		LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []ModerationEntry
+	for rows.Next() {
+		var e ModerationEntry
+		if err := rows.Scan(&e.ID, &e.ModeratorID, &e.ModeratorName, &e.Action, &e.ReportID,
+			&e.PostID, &e.CommentID, &e.TargetUserID, &e.TargetName, &e.Note, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// warn records a warning given to a user
+func warn(db execer, w *Warning) error {
+	result, err := db.Exec("INSERT INTO user_warnings (user_id, moderator_id, reason) VALUES (?, ?, ?)",
+		w.UserID, nullableID(w.ModeratorID), w.Reason)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	w.ID = int(id)
+	return err
+}
+
+func (s *sqliteModeration) ListWarnings(userID int) ([]Warning, error) {
+	rows, err := s.db.Query(`SELECT id, COALESCE(moderator_id, 0), reason, created_at FROM user_warnings
+		WHERE user_id = ? ORDER BY created_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var warnings []Warning
+	for rows.Next() {
+		w := Warning{UserID: userID}
+		if err := rows.Scan(&w.ID, &w.ModeratorID, &w.Reason, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		warnings = append(warnings, w)
+	}
+	return warnings, rows.Err()
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+type sqliteNotifications struct {
+	db *sql.DB
+}
+
+func (s *sqliteNotifications) Create(n *Notification) error {
+	result, err := s.db.Exec(`INSERT OR IGNORE INTO notifications (user_id, actor_id, kind, post_id, comment_id)
+		VALUES (?, ?, ?, ?, ?)`, n.UserID, n.ActorID, n.Kind, n.PostID, nullableID(n.CommentID))
+	if err != nil {
+		return err
+	}
+	// an unread copy already exists
+	if added, err := result.RowsAffected(); err != nil || added == 0 {
+		return err
+	}
+	id, err := result.LastInsertId()
+	n.ID = int(id)
+	return err
+}
+
+func (s *sqliteNotifications) ListByUser(userID, limit int) ([]Notification, error) {
+	rows, err := s.db.Query(`
+		SELECT n.id, n.user_id, n.actor_id, u.username, n.kind, n.post_id, p.title, COALESCE(n.comment_id, 0), n.created_at, n.read_at
+		FROM notifications n
+		JOIN users u ON u.id = n.actor_id
+		JOIN posts p ON p.id = n.post_id
+		WHERE n.user_id = ? AND n.actor_id NOT IN (`+shadowbannedUsers+`)
+		ORDER BY n.created_at DESC, n.id DESC
+		LIMIT ?`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	for rows.Next() {
+		var n Notification
+		var readAt sql.NullTime
+		if err := rows.Scan(&n.ID, &n.UserID, &n.ActorID, &n.ActorName, &n.Kind, &n.PostID, &n.PostTitle, &n.CommentID,
+			&n.CreatedAt, &readAt); err != nil {
+			return nil, err
+		}
+		if readAt.Valid {
+			n.ReadAt = &readAt.Time
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+func (s *sqliteNotifications) CountUnread(userID int) (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM notifications
+		WHERE user_id = ? AND read_at IS NULL AND actor_id NOT IN (`+shadowbannedUsers+`)`, userID).Scan(&count)
+	return count, err
+}
+
+func (s *sqliteNotifications) MarkRead(id, userID int, at time.Time) error {
+	result, err := s.db.Exec("UPDATE notifications SET read_at = COALESCE(read_at, ?) WHERE id = ? AND user_id = ?", at, id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteNotifications) MarkAllRead(userID int, at time.Time) error {
+	_, err := s.db.Exec("UPDATE notifications SET read_at = ? WHERE user_id = ? AND read_at IS NULL", at, userID)
+	return err
+}
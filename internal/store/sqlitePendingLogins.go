@@ -0,0 +1,39 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+type sqlitePendingLogins struct {
+	db *sql.DB
+}
+
+func (s *sqlitePendingLogins) Create(p *PendingLogin) error {
+	_, err := s.db.Exec("INSERT INTO pending_logins (token_hash, user_id, remember, expires_at) VALUES (?, ?, ?, ?)",
+		p.TokenHash, p.UserID, p.Remember, p.ExpiresAt)
+	return err
+}
+
+func (s *sqlitePendingLogins) Get(tokenHash string, now time.Time) (*PendingLogin, error) {
+	p := &PendingLogin{TokenHash: tokenHash}
+	err := s.db.QueryRow("SELECT user_id, remember, expires_at FROM pending_logins WHERE token_hash = ? AND expires_at > ?",
+		tokenHash, now).Scan(&p.UserID, &p.Remember, &p.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *sqlitePendingLogins) Delete(tokenHash string) error {
+	_, err := s.db.Exec("DELETE FROM pending_logins WHERE token_hash = ?", tokenHash)
+	return err
+}
+
+func (s *sqlitePendingLogins) DeleteExpired(now time.Time) error {
+	_, err := s.db.Exec("DELETE FROM pending_logins WHERE expires_at < ?", now)
+	return err
+}
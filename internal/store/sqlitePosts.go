@@ -0,0 +1,359 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+)
+
+type sqlitePosts struct {
+	db       *sql.DB
+	fullText bool
+}
+
+// postsSelect is the shared select used by the feed and the single post page.
+const postsSelect = `
+	SELECT
+	    p.id,
+	    p.user_id,
+	    p.title,
+	    p.content,
+	    p.created_at,
+	    p.updated_at,
+	    u.username,
+	    (SELECT COUNT(*) FROM post_reactions pr WHERE pr.post_id = p.id AND pr.liked = 1) AS likes,
+	    (SELECT COUNT(*) FROM post_reactions pr WHERE pr.post_id = p.id AND pr.liked = 0) AS dislikes,
+	    (SELECT COUNT(*) FROM comments cm WHERE cm.post_id = p.id AND cm.deleted = 0 AND cm.hidden_at IS NULL
+	        AND cm.user_id NOT IN (` + shadowbannedUsers + `)) AS comment_count,
+	    COALESCE(GROUP_CONCAT(DISTINCT c.name), '') AS categories,
+	    p.hidden_at IS NOT NULL,
+	    p.user_id IN (` + shadowbannedUsers + `)
+	FROM posts p
+	JOIN users u ON p.user_id = u.id
+	LEFT JOIN post_categories pc ON p.id = pc.post_id
+	LEFT JOIN categories c ON pc.category_id = c.id
+	WHERE 1=1
+	`
+
+// sortOrders maps the sort of the feed to its ORDER BY clause,
+// controversial posts are the ones with many likes and dislikes at once.
+var sortOrders = map[string]string{
+	"newest":        "p.created_at DESC, p.id DESC",
+	"oldest":        "p.created_at ASC, p.id ASC",
+	"liked":         "likes DESC, p.created_at DESC, p.id DESC",
+	"commented":     "comment_count DESC, p.created_at DESC, p.id DESC",
+	"controversial": "MIN(likes, dislikes) DESC, likes + dislikes DESC, p.created_at DESC, p.id DESC",
+}
+
+// postFilters returns the conditions on posts p selected by the filter,
+// shared by the feed and the search. Hidden posts are never listed, the posts
+// of shadowbanned users only to themselves.
+func postFilters(filter PostFilter) (string, []interface{}) {
+	filters := " AND p.hidden_at IS NULL AND (p.user_id = ? OR p.user_id NOT IN (" + shadowbannedUsers + "))"
+	args := []interface{}{filter.ViewerID}
+	if len(filter.CategoryIDs) > 0 {
+		marks, ids := placeholders(filter.CategoryIDs)
+		// a category matches the posts of its subcategories too
+		filters += ` AND p.id IN (SELECT post_id FROM post_categories WHERE category_id IN (
+			WITH RECURSIVE picked(id) AS (
+				SELECT id FROM categories WHERE id IN (` + marks + `)
+				UNION SELECT c.id FROM categories c JOIN picked ON c.parent_id = picked.id
+			) SELECT id FROM picked))`
+		args = append(args, ids...)
+	}
+
+	if filter.AuthorID != 0 {
+		filters += " AND p.user_id = ?"
+		args = append(args, filter.AuthorID)
+	}
+
+	if filter.LikedBy != 0 {
+		filters += " AND p.id IN (SELECT post_id FROM post_reactions WHERE user_id = ? AND liked = 1)"
+		args = append(args, filter.LikedBy)
+	}
+
+	return filters, args
+}
+
+func (s *sqlitePosts) List(filter PostFilter) ([]Post, error) {
+	filters, args := postFilters(filter)
+	query := postsSelect + filters
+
+	// complete the query with grouping, ordering and the page window.
+	order, ok := sortOrders[filter.Sort]
+	if !ok {
+		order = sortOrders["newest"]
+	}
+	query += " GROUP BY p.id ORDER BY " + order
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	return s.fetchPosts(query, args)
+}
+
+func (s *sqlitePosts) Get(id int) (*Post, error) {
+	posts, err := s.fetchPosts(postsSelect+" AND p.id = ? GROUP BY p.id", []interface{}{id})
+	if err != nil {
+		return nil, err
+	}
+	if len(posts) == 0 {
+		return nil, ErrNotFound
+	}
+	return &posts[0], nil
+}
+
+func (s *sqlitePosts) fetchPosts(query string, args []interface{}) ([]Post, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []Post
+	for rows.Next() {
+		var post Post
+		var categoriesStr string
+
+		if err := rows.Scan(
+			&post.ID,
+			&post.UserID,
+			&post.Title,
+			&post.Content,
+			&post.CreatedAt,
+			&post.UpdatedAt,
+			&post.Username,
+			&post.Likes,
+			&post.Dislikes,
+			&post.CommentCount,
+			&categoriesStr,
+			&post.Hidden,
+			&post.Shadowbanned,
+		); err != nil {
+			return nil, err
+		}
+		if categoriesStr != "" {
+			post.Categories = strings.Split(categoriesStr, ",")
+		} else {
+			post.Categories = []string{}
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+func (s *sqlitePosts) CategoryIDs(postID int) ([]int, error) {
+	rows, err := s.db.Query("SELECT category_id FROM post_categories WHERE post_id = ?", postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *sqlitePosts) Create(userID int, title, content string, categoryIDs []int) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO posts (user_id, title, content) VALUES (?, ?, ?)", userID, title, content)
+	if err != nil {
+		return 0, err
+	}
+	postID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := setPostCategories(tx, int(postID), categoryIDs); err != nil {
+		return 0, err
+	}
+	return int(postID), tx.Commit()
+}
+
+func (s *sqlitePosts) Update(id int, title, content string, categoryIDs []int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE posts SET title = ?, content = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		title, content, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+
+	//rewrite the categories of the post
+	if _, err = tx.Exec("DELETE FROM post_categories WHERE post_id = ?", id); err != nil {
+		return err
+	}
+	if err := setPostCategories(tx, id, categoryIDs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlitePosts) SetHidden(id int, hidden bool) error {
+	return setHidden(s.db, "posts", id, hidden)
+}
+
+// setHidden sets or clears hidden_at on a post or a comment, keeping the time
+// it was first hidden
+func setHidden(db execer, table string, id int, hidden bool) error {
+	query := "UPDATE " + table + " SET hidden_at = COALESCE(hidden_at, CURRENT_TIMESTAMP) WHERE id = ?"
+	if !hidden {
+		query = "UPDATE " + table + " SET hidden_at = NULL WHERE id = ?"
+	}
+	result, err := db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func setPostCategories(tx *sql.Tx, postID int, categoryIDs []int) error {
+	for _, catID := range categoryIDs {
+		_, err := tx.Exec("INSERT INTO post_categories (post_id, category_id) VALUES (?, ?)", postID, catID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a post with its comments, reactions go with them. Comments are
+// deleted explicitly since older databases lack the ON DELETE CASCADE on comments.
+func (s *sqlitePosts) Delete(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deletePost(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deletePost deletes a post with its comments
+func deletePost(tx *sql.Tx, id int) error {
+	if _, err := tx.Exec("DELETE FROM comments WHERE post_id = ?", id); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM posts WHERE id = ?", id)
+	return err
+}
+
+const maxSearchResults = 50
+
+// ftsQuery turns user input into an FTS5 query matching every word as a prefix,
+// quoting each word so the FTS5 syntax characters are taken literally.
+func ftsQuery(input string) string {
+	var terms []string
+	for _, word := range strings.Fields(input) {
+		word = strings.ReplaceAll(word, `"`, "")
+		if word != "" {
+			terms = append(terms, `"`+word+`"*`)
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// Search matches the full-text index, or plain LIKE patterns when FTS5 is not
+// available, combined with the same filters as the feed. It keeps the best
+// ranked match of each post.
+func (s *sqlitePosts) Search(sq SearchQuery) ([]SearchResult, error) {
+	match := ftsQuery(sq.Text)
+	if match == "" {
+		return nil, nil
+	}
+
+	var query string
+	var args []interface{}
+	if s.fullText {
+		query = `
+		SELECT p.id, p.title, u.username, p.created_at, s.kind = 'comment',
+		    snippet(search_index, -1, char(2), char(3), '…', 16)
+		FROM search_index s
+		JOIN posts p ON p.id = s.post_id
+		JOIN users u ON u.id = p.user_id
+		WHERE search_index MATCH ?
+		    AND (s.comment_id IS NULL OR s.comment_id NOT IN (SELECT id FROM comments
+		        WHERE hidden_at IS NOT NULL OR (user_id <> ? AND user_id IN (` + shadowbannedUsers + `))))`
+		args = append(args, match, sq.Filter.ViewerID)
+	} else {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.TrimSpace(sq.Text)) + "%"
+		query = `
+		SELECT p.id, p.title, u.username, p.created_at, 0, substr(p.content, 1, 200)
+		FROM posts p
+		JOIN users u ON u.id = p.user_id
+		WHERE (p.title LIKE ? ESCAPE '\' OR p.content LIKE ? ESCAPE '\'
+		    OR p.id IN (SELECT post_id FROM comments WHERE deleted = 0 AND hidden_at IS NULL AND content LIKE ? ESCAPE '\'
+		        AND (user_id = ? OR user_id NOT IN (` + shadowbannedUsers + `))))`
+		args = append(args, pattern, pattern, pattern, sq.Filter.ViewerID)
+	}
+
+	filters, filterArgs := postFilters(sq.Filter)
+	query += filters
+	args = append(args, filterArgs...)
+
+	if author := strings.TrimSpace(sq.Author); author != "" {
+		query += " AND u.username = ? COLLATE NOCASE"
+		args = append(args, author)
+	}
+
+	if s.fullText {
+		// title matches weigh more than body matches
+		query += " ORDER BY bm25(search_index, 0, 0, 0, 10.0, 1.0)"
+	} else {
+		query += " ORDER BY p.created_at DESC"
+	}
+	query += " LIMIT ?"
+	args = append(args, maxSearchResults*4)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	seen := make(map[int]bool)
+	for rows.Next() {
+		var res SearchResult
+		if err := rows.Scan(&res.PostID, &res.Title, &res.Username, &res.CreatedAt, &res.InComment, &res.Snippet); err != nil {
+			return nil, err
+		}
+		if seen[res.PostID] || len(results) == maxSearchResults {
+			continue
+		}
+		seen[res.PostID] = true
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
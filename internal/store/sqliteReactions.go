@@ -0,0 +1,47 @@
+package store
+
+import "database/sql"
+
+type sqliteReactions struct {
+	db *sql.DB
+}
+
+func (s *sqliteReactions) TogglePost(postID, userID int, liked bool) (bool, error) {
+	return s.toggle("post_reactions", "post_id", postID, userID, liked)
+}
+
+func (s *sqliteReactions) ToggleComment(commentID, userID int, liked bool) (bool, error) {
+	return s.toggle("comment_reactions", "comment_id", commentID, userID, liked)
+}
+
+// toggle is shared by posts and comments, table and column are never user input
+func (s *sqliteReactions) toggle(table, column string, targetID, userID int, liked bool) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	//check if there's already a reaction from the user
+	var currentReaction, added bool
+	err = tx.QueryRow("SELECT liked FROM "+table+" WHERE "+column+" = ? AND user_id = ?", targetID, userID).Scan(&currentReaction)
+	switch {
+	case err == sql.ErrNoRows:
+		//no reaction yet, add it
+		_, err = tx.Exec("INSERT INTO "+table+" ("+column+", user_id, liked) VALUES (?, ?, ?)", targetID, userID, liked)
+		added = true
+	case err != nil:
+		return false, err
+	case currentReaction == liked:
+		//same reaction again removes it
+		_, err = tx.Exec("DELETE FROM "+table+" WHERE "+column+" = ? AND user_id = ?", targetID, userID)
+	default:
+		//switch between like and dislike
+		_, err = tx.Exec("UPDATE "+table+" SET liked = ? WHERE "+column+" = ? AND user_id = ?", liked, targetID, userID)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return added, tx.Commit()
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+type sqliteReports struct {
+	db *sql.DB
+}
+
+// nullableID stores a missing reference as NULL
+func nullableID(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+const reportsSelect = `
+	SELECT r.id, r.reporter_id, u.username, COALESCE(r.post_id, 0), COALESCE(r.comment_id, 0),
+		r.reason, r.details, r.created_at, r.resolved_at, COALESCE(r.resolution, '')
+	FROM reports r
+	JOIN users u ON u.id = r.reporter_id
+	`
+
+func scanReport(row interface{ Scan(...interface{}) error }, r *Report) error {
+	var resolvedAt sql.NullTime
+	err := row.Scan(&r.ID, &r.ReporterID, &r.ReporterName, &r.PostID, &r.CommentID,
+		&r.Reason, &r.Details, &r.CreatedAt, &resolvedAt, &r.Resolution)
+	if resolvedAt.Valid {
+		r.ResolvedAt = &resolvedAt.Time
+	}
+	return err
+}
+
+func (s *sqliteReports) Create(r *Report) error {
+	result, err := s.db.Exec("INSERT INTO reports (reporter_id, post_id, comment_id, reason, details) VALUES (?, ?, ?, ?, ?)",
+		r.ReporterID, nullableID(r.PostID), nullableID(r.CommentID), r.Reason, r.Details)
+	if isUnique(err) {
+		return ErrDuplicate
+	}
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	r.ID = int(id)
+	return err
+}
+
+func (s *sqliteReports) Get(id int) (*Report, error) {
+	var r Report
+	err := scanReport(s.db.QueryRow(reportsSelect+" WHERE r.id = ?", id), &r)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *sqliteReports) ListOpen() ([]Report, error) {
+	rows, err := s.db.Query(reportsSelect + " WHERE r.resolved_at IS NULL ORDER BY r.created_at, r.id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []Report
+	for rows.Next() {
+		var r Report
+		if err := scanReport(rows, &r); err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+// resolveReports closes every open report on a post or a comment
+func resolveReports(db execer, postID, commentID, moderatorID int, resolution string, at time.Time) error {
+	_, err := db.Exec(`UPDATE reports SET resolved_at = ?, resolved_by = ?, resolution = ?
+		WHERE resolved_at IS NULL AND (post_id = ? OR comment_id = ?)`,
+		at, moderatorID, resolution, nullableID(postID), nullableID(commentID))
+	return err
+}
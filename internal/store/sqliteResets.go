@@ -0,0 +1,47 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+type sqliteResets struct {
+	db *sql.DB
+}
+
+func (s *sqliteResets) Create(tokenHash string, userID int, expiresAt time.Time) error {
+	_, err := s.db.Exec("INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES (?, ?, ?)",
+		tokenHash, userID, expiresAt)
+	return err
+}
+
+func (s *sqliteResets) UserID(tokenHash string, now time.Time) (int, error) {
+	var userID int
+	err := s.db.QueryRow("SELECT user_id FROM password_resets WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?",
+		tokenHash, now).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return userID, err
+}
+
+func (s *sqliteResets) Consume(tokenHash string, now time.Time) (int, error) {
+	var userID int
+	err := s.db.QueryRow(`UPDATE password_resets SET used_at = ?
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+		RETURNING user_id`, now, tokenHash, now).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return userID, err
+}
+
+func (s *sqliteResets) DeleteByUser(userID int) error {
+	_, err := s.db.Exec("DELETE FROM password_resets WHERE user_id = ?", userID)
+	return err
+}
+
+func (s *sqliteResets) DeleteExpired(now time.Time) error {
+	_, err := s.db.Exec("DELETE FROM password_resets WHERE expires_at < ?", now)
+	return err
+}
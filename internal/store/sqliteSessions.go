@@ -0,0 +1,84 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+type sqliteSessions struct {
+	db *sql.DB
+}
+
+const sessionsSelect = `
+	SELECT s.id, s.user_id, u.username, u.email_verified_at IS NOT NULL, u.role, s.csrf_token, s.user_agent, s.ip, s.remember, s.created_at, s.last_seen_at, s.expires_at
+	FROM sessions s
+	JOIN users u ON s.user_id = u.id
+	`
+
+func scanSession(row interface{ Scan(...interface{}) error }, s *Session) error {
+	return row.Scan(&s.ID, &s.UserID, &s.Username, &s.EmailVerified, &s.Role, &s.CSRFToken, &s.UserAgent, &s.IP, &s.Remember, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt)
+}
+
+func (s *sqliteSessions) Create(session *Session) error {
+	_, err := s.db.Exec(`INSERT INTO sessions (id, user_id, csrf_token, user_agent, ip, remember, created_at, last_seen_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		session.ID, session.UserID, session.CSRFToken, session.UserAgent, session.IP, session.Remember,
+		session.CreatedAt, session.LastSeenAt, session.ExpiresAt)
+	return err
+}
+
+func (s *sqliteSessions) Get(id string) (*Session, error) {
+	var session Session
+	err := scanSession(s.db.QueryRow(sessionsSelect+" WHERE s.id = ? AND s.expires_at > ?", id, time.Now()), &session)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *sqliteSessions) ListByUser(userID int) ([]Session, error) {
+	rows, err := s.db.Query(sessionsSelect+" WHERE s.user_id = ? AND s.expires_at > ? ORDER BY s.last_seen_at DESC",
+		userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		if err := scanSession(rows, &session); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *sqliteSessions) Touch(id string, at, expiresAt time.Time) error {
+	_, err := s.db.Exec("UPDATE sessions SET last_seen_at = ?, expires_at = ? WHERE id = ?", at, expiresAt, id)
+	return err
+}
+
+func (s *sqliteSessions) Delete(id string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE id = ?", id)
+	return err
+}
+
+func (s *sqliteSessions) DeleteByUser(userID int) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
+	return err
+}
+
+func (s *sqliteSessions) DeleteOthers(userID int, keepID string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE user_id = ? AND id != ?", userID, keepID)
+	return err
+}
+
+func (s *sqliteSessions) DeleteExpired() error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE expires_at < ?", time.Now())
+	return err
+}
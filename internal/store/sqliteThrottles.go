@@ -0,0 +1,60 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+type sqliteThrottles struct {
+	db *sql.DB
+}
+
+func (s *sqliteThrottles) Get(key string) (*Throttle, error) {
+	t := Throttle{Key: key}
+	var lockedUntil sql.NullTime
+	err := s.db.QueryRow("SELECT attempts, last_attempt_at, locked_until FROM auth_throttles WHERE key = ?", key).
+		Scan(&t.Attempts, &t.LastAttemptAt, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if lockedUntil.Valid {
+		t.LockedUntil = &lockedUntil.Time
+	}
+	return &t, nil
+}
+
+func (s *sqliteThrottles) AddAttempt(key string, now time.Time, window time.Duration) (int, error) {
+	var attempts int
+	err := s.db.QueryRow(`
+		INSERT INTO auth_throttles (key, attempts, last_attempt_at) VALUES (?, 1, ?)
+		ON CONFLICT(key) DO UPDATE SET
+		    attempts = CASE WHEN last_attempt_at < ? THEN 1 ELSE attempts + 1 END,
+		    last_attempt_at = excluded.last_attempt_at
+		RETURNING attempts`, key, now, now.Add(-window)).Scan(&attempts)
+	return attempts, err
+}
+
+func (s *sqliteThrottles) SetLockedUntil(key string, until time.Time) error {
+	_, err := s.db.Exec("UPDATE auth_throttles SET locked_until = ? WHERE key = ?", until, key)
+	return err
+}
+
+func (s *sqliteThrottles) Reset(key string) error {
+	_, err := s.db.Exec("DELETE FROM auth_throttles WHERE key = ?", key)
+	return err
+}
+
+func (s *sqliteThrottles) DeleteExpired(before, now time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM auth_throttles
+		WHERE last_attempt_at < ? AND (locked_until IS NULL OR locked_until < ?)`, before, now)
+	return err
+}
+
+func (s *sqliteThrottles) LogAttempt(a *AuthAttempt) error {
+	_, err := s.db.Exec("INSERT INTO auth_attempts (kind, ip, email, reason) VALUES (?, ?, ?, ?)",
+		a.Kind, a.IP, a.Email, a.Reason)
+	return err
+}
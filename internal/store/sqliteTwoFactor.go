@@ -0,0 +1,131 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+type sqliteTwoFactor struct {
+	db *sql.DB
+}
+
+func (s *sqliteTwoFactor) Get(userID int) (*TOTP, error) {
+	t := &TOTP{UserID: userID}
+	var enabledAt sql.NullTime
+	err := s.db.QueryRow("SELECT secret, enabled_at, last_step FROM user_totp WHERE user_id = ?", userID).
+		Scan(&t.Secret, &enabledAt, &t.LastStep)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if enabledAt.Valid {
+		t.EnabledAt = &enabledAt.Time
+	}
+	return t, nil
+}
+
+func (s *sqliteTwoFactor) SetPending(userID int, secret string) error {
+	_, err := s.db.Exec(`INSERT INTO user_totp (user_id, secret) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, last_step = 0
+		WHERE user_totp.enabled_at IS NULL`, userID, secret)
+	return err
+}
+
+func (s *sqliteTwoFactor) Enable(userID int, at time.Time, step int64, codeHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE user_totp SET enabled_at = ?, last_step = ? WHERE user_id = ? AND enabled_at IS NULL",
+		at, step, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteTwoFactor) UseStep(userID int, step int64) (bool, error) {
+	res, err := s.db.Exec("UPDATE user_totp SET last_step = ? WHERE user_id = ? AND last_step < ?",
+		step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *sqliteTwoFactor) UseRecoveryCode(userID int, codeHash string, at time.Time) error {
+	res, err := s.db.Exec("UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		at, userID, codeHash)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteTwoFactor) RecoveryCodesLeft(userID int) (int, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&n)
+	return n, err
+}
+
+func (s *sqliteTwoFactor) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// replaceRecoveryCodes swaps all the codes of the user for new ones
+func replaceRecoveryCodes(tx *sql.Tx, userID int, codeHashes []string) error {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (code_hash, user_id) VALUES (?, ?)", hash, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqliteTwoFactor) Disable(userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_totp WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+type sqliteUsers struct {
+	db *sql.DB
+}
+
+func (s *sqliteUsers) Create(username, email, passwordHash string) (int, error) {
+	// the first user of the forum becomes its admin
+	result, err := s.db.Exec(`INSERT INTO users (username, email, password, role)
+		VALUES (?, ?, ?, CASE WHEN EXISTS (SELECT 1 FROM users) THEN 'user' ELSE 'admin' END)`,
+		username, email, passwordHash)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+func (s *sqliteUsers) GetByID(id int) (*User, error) {
+	return s.get("id = ?", id)
+}
+
+func (s *sqliteUsers) GetByEmail(email string) (*User, error) {
+	return s.get("email = ?", email)
+}
+
+func (s *sqliteUsers) GetByUsername(username string) (*User, error) {
+	return s.get("username = ?", username)
+}
+
+func (s *sqliteUsers) get(where string, arg interface{}) (*User, error) {
+	var u User
+	var verifiedAt sql.NullTime
+	err := s.db.QueryRow("SELECT id, username, email, password, created_at, email_verified_at, role FROM users WHERE "+where, arg).
+		Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.CreatedAt, &verifiedAt, &u.Role)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if verifiedAt.Valid {
+		u.EmailVerifiedAt = &verifiedAt.Time
+	}
+	return &u, nil
+}
+
+func (s *sqliteUsers) EmailExists(email string) (bool, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE email = ?", email).Scan(&count)
+	return count > 0, err
+}
+
+func (s *sqliteUsers) UsernameExists(username string) (bool, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", username).Scan(&count)
+	return count > 0, err
+}
+
+func (s *sqliteUsers) UpdatePassword(id int, passwordHash string) error {
+	result, err := s.db.Exec("UPDATE users SET password = ? WHERE id = ?", passwordHash, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteUsers) MarkEmailVerified(id int, at time.Time) error {
+	_, err := s.db.Exec("UPDATE users SET email_verified_at = ? WHERE id = ? AND email_verified_at IS NULL", at, id)
+	return err
+}
+
+func (s *sqliteUsers) List() ([]User, error) {
+	rows, err := s.db.Query("SELECT id, username, email, created_at, email_verified_at, role FROM users ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var u User
+		var verifiedAt sql.NullTime
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.CreatedAt, &verifiedAt, &u.Role); err != nil {
+			return nil, err
+		}
+		if verifiedAt.Valid {
+			u.EmailVerifiedAt = &verifiedAt.Time
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (s *sqliteUsers) SetRole(id int, role string) error {
+	result, err := s.db.Exec("UPDATE users SET role = ? WHERE id = ?", role, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteUsers) CountRole(role string) (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE role = ?", role).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,36 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+type sqliteVerifications struct {
+	db *sql.DB
+}
+
+func (s *sqliteVerifications) Create(tokenHash string, userID int, expiresAt time.Time) error {
+	_, err := s.db.Exec("INSERT INTO email_verifications (token_hash, user_id, expires_at) VALUES (?, ?, ?)",
+		tokenHash, userID, expiresAt)
+	return err
+}
+
+func (s *sqliteVerifications) Consume(tokenHash string, now time.Time) (int, error) {
+	var userID int
+	err := s.db.QueryRow("DELETE FROM email_verifications WHERE token_hash = ? AND expires_at > ? RETURNING user_id",
+		tokenHash, now).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return userID, err
+}
+
+func (s *sqliteVerifications) DeleteByUser(userID int) error {
+	_, err := s.db.Exec("DELETE FROM email_verifications WHERE user_id = ?", userID)
+	return err
+}
+
+func (s *sqliteVerifications) DeleteExpired(now time.Time) error {
+	_, err := s.db.Exec("DELETE FROM email_verifications WHERE expires_at < ?", now)
+	return err
+}
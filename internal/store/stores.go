@@ -0,0 +1,266 @@
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned when the requested row does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrDuplicate is returned when a unique value, like a category name, is taken
+var ErrDuplicate = errors.New("already exists")
+
+type UserStore interface {
+	Create(username, email, passwordHash string) (int, error)
+	GetByID(id int) (*User, error)
+	GetByEmail(email string) (*User, error)
+	EmailExists(email string) (bool, error)
+	UsernameExists(username string) (bool, error)
+	GetByUsername(username string) (*User, error)
+	UpdatePassword(id int, passwordHash string) error
+	MarkEmailVerified(id int, at time.Time) error
+	// List returns the users by username, for the admin pages
+	List() ([]User, error)
+	SetRole(id int, role string) error
+	CountRole(role string) (int, error)
+}
+
+type SessionStore interface {
+	Create(session *Session) error
+	// Get returns the session if it has not expired, with the username of its user
+	Get(id string) (*Session, error)
+	// ListByUser returns the active sessions of a user, most recently seen first
+	ListByUser(userID int) ([]Session, error)
+	// Touch records that the session was used at the given time and moves its expiry
+	Touch(id string, at, expiresAt time.Time) error
+	Delete(id string) error
+	DeleteByUser(userID int) error
+	// DeleteOthers ends every session of a user except keepID
+	DeleteOthers(userID int, keepID string) error
+	DeleteExpired() error
+}
+
+type PostStore interface {
+	List(filter PostFilter) ([]Post, error)
+	Get(id int) (*Post, error)
+	CategoryIDs(postID int) ([]int, error)
+	Create(userID int, title, content string, categoryIDs []int) (int, error)
+	// Update replaces the title, content and categories of a post and marks it edited
+	Update(id int, title, content string, categoryIDs []int) error
+	Delete(id int) error
+	// SetHidden hides a post from everyone but its author and the moderators, or shows it again
+	SetHidden(id int, hidden bool) error
+	Search(query SearchQuery) ([]SearchResult, error)
+}
+
+type CommentStore interface {
+	// ListByPosts returns the comments of each post, oldest first, leaving out the
+	// ones of shadowbanned users unless viewerID wrote them
+	ListByPosts(postIDs []int, viewerID int) (map[int][]Comment, error)
+	Get(id int) (*Comment, error)
+	Create(postID, userID int, content string, parentID *int) (int, error)
+	Update(id int, content string) error
+	// SoftDelete keeps the row as a placeholder, dropping its content and reactions
+	SoftDelete(id int) error
+	SetHidden(id int, hidden bool) error
+}
+
+type ReactionStore interface {
+	// TogglePost adds the reaction, removes it when it is already there,
+	// or switches a like to a dislike and back. added tells whether the
+	// user had no reaction before.
+	TogglePost(postID, userID int, liked bool) (added bool, err error)
+	ToggleComment(commentID, userID int, liked bool) (added bool, err error)
+}
+
+type ThrottleStore interface {
+	// Get returns the throttle of key, ErrNotFound when it has no recent attempts
+	Get(key string) (*Throttle, error)
+	// AddAttempt counts an attempt of key at now, starting over when the previous
+	// one is older than window, and returns the new count
+	AddAttempt(key string, now time.Time, window time.Duration) (int, error)
+	SetLockedUntil(key string, until time.Time) error
+	Reset(key string) error
+	// DeleteExpired forgets the keys without attempts since before that are not locked
+	DeleteExpired(before, now time.Time) error
+	// LogAttempt audits a failed or refused login or registration
+	LogAttempt(attempt *AuthAttempt) error
+}
+
+type PasswordResetStore interface {
+	Create(tokenHash string, userID int, expiresAt time.Time) error
+	// UserID returns the user of an unused, unexpired token, ErrNotFound otherwise
+	UserID(tokenHash string, now time.Time) (int, error)
+	// Consume marks an unused, unexpired token as used and returns its user,
+	// ErrNotFound otherwise; only one caller can consume a token
+	Consume(tokenHash string, now time.Time) (int, error)
+	DeleteByUser(userID int) error
+	DeleteExpired(now time.Time) error
+}
+
+type EmailVerificationStore interface {
+	Create(tokenHash string, userID int, expiresAt time.Time) error
+	// Consume deletes an unexpired token and returns its user, ErrNotFound otherwise
+	Consume(tokenHash string, now time.Time) (int, error)
+	DeleteByUser(userID int) error
+	DeleteExpired(now time.Time) error
+}
+
+type CategoryStore interface {
+	// List returns every category, archived ones included, parents before their
+	// children and siblings by position
+	List() ([]Category, error)
+	Get(id int) (*Category, error)
+	// Create adds the category after its siblings, ErrDuplicate if the name is taken
+	Create(name, description string, parentID int) (int, error)
+	// Update renames and moves a category, ErrDuplicate if the name is taken
+	Update(id int, name, description string, parentID int) error
+	// Move swaps the category with the sibling before or after it
+	Move(id int, up bool) error
+	SetArchived(id int, archived bool) error
+	// Merge moves the posts and subcategories of from into into, then deletes from
+	Merge(fromID, intoID int) error
+}
+
+type IdentityStore interface {
+	// Get returns the identity of a provider account, ErrNotFound if it is not linked
+	Get(provider, subject string) (*Identity, error)
+	ListByUser(userID int) ([]Identity, error)
+	Create(i *Identity) error
+	Delete(userID int, provider string) error
+}
+
+type TwoFactorStore interface {
+	// Get returns the TOTP of the user, ErrNotFound if they never started enrolling
+	Get(userID int) (*TOTP, error)
+	// SetPending stores the secret of an enrollment, unless 2FA is already enabled
+	SetPending(userID int, secret string) error
+	// Enable turns on the enrolled secret at the confirmed step, with its recovery codes
+	Enable(userID int, at time.Time, step int64, codeHashes []string) error
+	// UseStep records a time step as used and reports false if it is not newer
+	// than the last one, so each code works once
+	UseStep(userID int, step int64) (bool, error)
+	// UseRecoveryCode marks an unused code as used, ErrNotFound otherwise
+	UseRecoveryCode(userID int, codeHash string, at time.Time) error
+	RecoveryCodesLeft(userID int) (int, error)
+	ReplaceRecoveryCodes(userID int, codeHashes []string) error
+	// Disable deletes the secret and the recovery codes
+	Disable(userID int) error
+}
+
+type PendingLoginStore interface {
+	Create(p *PendingLogin) error
+	// Get returns an unexpired pending login, ErrNotFound otherwise
+	Get(tokenHash string, now time.Time) (*PendingLogin, error)
+	Delete(tokenHash string) error
+	DeleteExpired(now time.Time) error
+}
+
+type ReportStore interface {
+	// Create files a report, ErrDuplicate if the reporter has an open one on the same content
+	Create(r *Report) error
+	Get(id int) (*Report, error)
+	// ListOpen returns the unresolved reports, oldest first
+	ListOpen() ([]Report, error)
+}
+
+type ModerationStore interface {
+	// Apply carries out an action on a post or a comment together with its entry
+	// in the log, so neither happens without the other. ErrNotFound if the content
+	// to hide is gone.
+	Apply(action *ModerationAction) error
+	// ListLog returns the latest entries, newest first
+	ListLog(limit int) ([]ModerationEntry, error)
+	// ListWarnings returns the warnings of a user, newest first
+	ListWarnings(userID int) ([]Warning, error)
+}
+
+type BanStore interface {
+	// Create puts a ban in force and writes its entry to the moderation log, in one
+	// transaction so neither happens without the other
+	Create(b *Ban, entry *ModerationEntry) error
+	// Active returns the bans of a user in force at now, latest first
+	Active(userID int, now time.Time) ([]Ban, error)
+	// ListActive returns the bans of every user in force at now, latest first
+	ListActive(now time.Time) ([]Ban, error)
+	// Lift ends a ban in force together with writing its log entry, ErrNotFound if
+	// there is none with that id
+	Lift(id int, at time.Time, entry *ModerationEntry) error
+}
+
+type APITokenStore interface {
+	Create(t *APIToken, tokenHash string) error
+	// Get returns the unexpired and unrevoked token with that hash, ErrNotFound otherwise
+	Get(tokenHash string, now time.Time) (*APIToken, error)
+	// ListByUser returns the tokens of a user that were not revoked, newest first
+	ListByUser(userID int) ([]APIToken, error)
+	Touch(id int, at time.Time) error
+	// Revoke ends a token of the user, ErrNotFound if they have none with that id
+	Revoke(id, userID int, at time.Time) error
+}
+
+type NotificationStore interface {
+	// Create adds a notification, unless the same one is still unread
+	Create(n *Notification) error
+	// ListByUser returns the latest notifications of a user, newest first,
+	// leaving out the ones caused by shadowbanned users
+	ListByUser(userID, limit int) ([]Notification, error)
+	CountUnread(userID int) (int, error)
+	// MarkRead marks a notification of the user read, ErrNotFound if they have
+	// none with that id
+	MarkRead(id, userID int, at time.Time) error
+	MarkAllRead(userID int, at time.Time) error
+}
+
+// MessageStore keeps the private conversations. Messages of shadowbanned users
+// are only listed and counted for themselves.
+type MessageStore interface {
+	// Open returns the conversation of two users, creating it the first time
+	Open(userID, otherID int) (*Conversation, error)
+	// Get returns a conversation as the user sees it, ErrNotFound unless they are in it
+	Get(id, userID int) (*Conversation, error)
+	// ListByUser returns the conversations of a user with messages, latest first
+	ListByUser(userID int) ([]Conversation, error)
+	// ListMessages returns the latest messages of a conversation as the user sees
+	// them, oldest first
+	ListMessages(conversationID, userID, limit int) ([]Message, error)
+	Send(m *Message) error
+	// MarkRead records that the user read the conversation up to a message
+	MarkRead(conversationID, userID, messageID int) error
+	CountUnread(userID int) (int, error)
+}
+
+// BlockStore keeps who blocked whom, users blocked either way cannot message each other
+type BlockStore interface {
+	Block(blockerID, blockedID int) error
+	// Unblock lifts a block, ErrNotFound if there is none
+	Unblock(blockerID, blockedID int) error
+	// ListBlocked returns the users a user blocked, by username
+	ListBlocked(userID int) ([]Block, error)
+	// Between tells whether either user blocked the other
+	Between(userID, otherID int) (bool, error)
+}
+
+// Stores groups the stores the handlers depend on
+type Stores struct {
+	Users         UserStore
+	Sessions      SessionStore
+	Posts         PostStore
+	Comments      CommentStore
+	Reactions     ReactionStore
+	Categories    CategoryStore
+	Throttles     ThrottleStore
+	Resets        PasswordResetStore
+	Verifications EmailVerificationStore
+	TwoFactor     TwoFactorStore
+	Identities    IdentityStore
+	PendingLogins PendingLoginStore
+	Reports       ReportStore
+	Moderation    ModerationStore
+	Bans          BanStore
+	APITokens     APITokenStore
+	Notifications NotificationStore
+	Messages      MessageStore
+	Blocks        BlockStore
+}
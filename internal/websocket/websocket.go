@@ -0,0 +1,323 @@
+// Package websocket implements the server side of the WebSocket protocol (RFC 6455)
+// as far as the forum needs it: text messages, pings and the closing handshake on
+// top of a connection hijacked from net/http. Extensions and subprotocols are not
+// offered, binary messages are refused.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// acceptGUID is appended to the key of the client to prove the server speaks WebSocket
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// writeTimeout bounds every write, so a client that stops reading cannot hold a writer
+const writeTimeout = 10 * time.Second
+
+// Opcodes of the frames
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close codes sent when the connection ends
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseProtocolError = 1002
+	CloseUnsupported   = 1003
+	CloseInvalidData   = 1007
+	ClosePolicy        = 1008
+	CloseTooBig        = 1009
+)
+
+// ErrClosed is returned once the connection was closed by either side
+var ErrClosed = errors.New("websocket: connection closed")
+
+// Conn is an upgraded connection. One goroutine may read while others write, the
+// writes are serialized.
+type Conn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	maxSize     int
+	readTimeout time.Duration
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// headerHas tells whether a comma separated header holds the token, ignoring case
+func headerHas(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Check tells whether the request is a WebSocket handshake the server accepts,
+// returning an error status and message when it is not. Browsers send the cookies
+// of the forum whatever page opens the socket, so one opened from another site is
+// refused by its Origin.
+func Check(r *http.Request) (int, string) {
+	if r.Method != http.MethodGet {
+		return http.StatusMethodNotAllowed, "Invalid method"
+	}
+	if !headerHas(r.Header, "Connection", "upgrade") || !headerHas(r.Header, "Upgrade", "websocket") {
+		return http.StatusUpgradeRequired, "This address only takes WebSocket connections"
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return http.StatusUpgradeRequired, "Unsupported WebSocket version"
+	}
+	key, err := base64.StdEncoding.DecodeString(r.Header.Get("Sec-WebSocket-Key"))
+	if err != nil || len(key) != 16 {
+		return http.StatusBadRequest, "Invalid WebSocket key"
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, r.Host) {
+			return http.StatusForbidden, "Cross-origin WebSocket connections are not allowed"
+		}
+	}
+	return 0, ""
+}
+
+// acceptKey is the Sec-WebSocket-Accept answering the key of the client
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Upgrade takes over the connection of a request that passed Check and answers the
+// handshake. Messages longer than maxSize bytes end the connection, and so does a
+// client that sends nothing, not even a pong, for readTimeout.
+func Upgrade(w http.ResponseWriter, r *http.Request, maxSize int, readTimeout time.Duration) (*Conn, error) {
+	// cookies the middlewares refreshed go out with the handshake
+	cookies := w.Header().Values("Set-Cookie")
+
+	// the response controller reaches the connection through the writers wrapping it
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// the deadlines of the server stay on a hijacked connection
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	b.WriteString("Sec-WebSocket-Accept: " + acceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n")
+	for _, cookie := range cookies {
+		b.WriteString("Set-Cookie: " + cookie + "\r\n")
+	}
+	b.WriteString("\r\n")
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := io.WriteString(conn, b.String()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, br: brw.Reader, maxSize: maxSize, readTimeout: readTimeout}, nil
+}
+
+// readFrame reads the next frame of the client, unmasked
+func (c *Conn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	if c.readTimeout > 0 {
+		if err := c.conn.SetReadDeadline(time.Now().Add(c.readTimeout)); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = head[0]&0x80 != 0
+	op = head[0] & 0x0f
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	if head[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "unmasked frame from the client")
+	}
+
+	size := uint64(head[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= opClose && (!fin || size > 125) {
+		return false, 0, nil, c.fail(CloseProtocolError, "invalid control frame")
+	}
+	if size > uint64(c.maxSize) {
+		return false, 0, nil, c.fail(CloseTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// ReadMessage returns the next text message of the client. It answers pings and
+// the closing handshake on the way, and returns ErrClosed once the client closed
+// the connection.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var message []byte
+	started := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			// the close is echoed with the code of the client when it sent a usable one
+			code := CloseNormal
+			if len(payload) >= 2 {
+				if sent := int(binary.BigEndian.Uint16(payload)); sent >= 1000 && sent < 5000 && (sent < 1004 || sent > 1006) && sent != 1015 {
+					code = sent
+				}
+			}
+			c.CloseWith(code, "")
+			return nil, ErrClosed
+		case opText:
+			if started {
+				return nil, c.fail(CloseProtocolError, "new message before the last one ended")
+			}
+			started = true
+			message = payload
+		case opContinuation:
+			if !started {
+				return nil, c.fail(CloseProtocolError, "continuation without a message")
+			}
+			if len(message)+len(payload) > c.maxSize {
+				return nil, c.fail(CloseTooBig, "message too big")
+			}
+			message = append(message, payload...)
+		case opBinary:
+			return nil, c.fail(CloseUnsupported, "only text messages are accepted")
+		default:
+			return nil, c.fail(CloseProtocolError, "unknown opcode")
+		}
+		if fin {
+			if !utf8.Valid(message) {
+				return nil, c.fail(CloseInvalidData, "message is not valid UTF-8")
+			}
+			return message, nil
+		}
+	}
+}
+
+// fail closes the connection on a client that broke the protocol
+func (c *Conn) fail(code int, reason string) error {
+	c.CloseWith(code, reason)
+	return errors.New("websocket: " + reason)
+}
+
+// writeFrame sends a whole message in one frame, servers do not mask them
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	if op == opClose {
+		c.closed = true
+	}
+
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|op)
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+
+	if err := c.conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// WriteMessage sends a text message
+func (c *Conn) WriteMessage(data []byte) error {
+	return c.writeFrame(opText, data)
+}
+
+// Ping asks the client for a pong, which keeps the connection within its read timeout
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+// CloseWith sends a close frame with the code and reason, then closes the connection.
+// It can be called more than once.
+func (c *Conn) CloseWith(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload = append(payload, reason...)
+	err := c.writeFrame(opClose, payload)
+	c.conn.Close()
+	if errors.Is(err, ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// Close ends the connection normally
+func (c *Conn) Close() error {
+	return c.CloseWith(CloseNormal, "")
+}
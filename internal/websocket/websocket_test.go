@@ -0,0 +1,369 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the example handshake of RFC 6455, section 1.3
+const (
+	rfcKey    = "dGhlIHNhbXBsZSBub25jZQ=="
+	rfcAccept = "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+)
+
+// pipe returns a server connection reading messages of at most maxSize bytes and
+// the client end of it
+func pipe(t *testing.T, maxSize int) (*Conn, net.Conn) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	// a test that goes wrong fails instead of hanging
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return &Conn{conn: server, br: bufio.NewReader(server), maxSize: maxSize}, client
+}
+
+// clientFrame builds a frame as a client sends it, masked unless told otherwise
+func clientFrame(fin bool, op byte, payload []byte, masked bool) []byte {
+	first := op
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	maskBit := byte(0)
+	if masked {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	if !masked {
+		return append(frame, payload...)
+	}
+	mask := [4]byte{0x37, 0xfa, 0x21, 0x3d}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+// send writes the frames of the client in the background, the server closes the
+// pipe under them when it gives up early
+func send(client net.Conn, frames ...[]byte) {
+	go func() {
+		for _, frame := range frames {
+			if _, err := client.Write(frame); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// serverFrame reads a frame of the server on the client end
+func serverFrame(t *testing.T, r io.Reader) (byte, []byte) {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		t.Fatalf("reading a frame of the server: %v", err)
+	}
+	if head[0]&0x80 == 0 {
+		t.Fatal("the server sent a fragmented frame")
+	}
+	if head[1]&0x80 != 0 {
+		t.Fatal("the server sent a masked frame")
+	}
+	size := int(head[1] & 0x7f)
+	if size == 126 {
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			t.Fatal(err)
+		}
+		size = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0x0f, payload
+}
+
+// readResult is what ReadMessage returned
+type readResult struct {
+	message []byte
+	err     error
+}
+
+// read runs ReadMessage in the background, it blocks on the pipe until the client reads
+// what the server writes
+func read(c *Conn) <-chan readResult {
+	done := make(chan readResult, 1)
+	go func() {
+		message, err := c.ReadMessage()
+		done <- readResult{message, err}
+	}()
+	return done
+}
+
+// expectClose checks the server closed the connection with the code
+func expectClose(t *testing.T, client net.Conn, done <-chan readResult, code int) {
+	t.Helper()
+	op, payload := serverFrame(t, client)
+	if op != opClose || len(payload) < 2 {
+		t.Fatalf("got frame %#x %q, want a close frame", op, payload)
+	}
+	if got := int(binary.BigEndian.Uint16(payload)); got != code {
+		t.Errorf("close code %d (%s), want %d", got, payload[2:], code)
+	}
+	if res := <-done; res.err == nil {
+		t.Errorf("ReadMessage returned %q, want an error", res.message)
+	}
+}
+
+func TestAcceptKey(t *testing.T) {
+	if got := acceptKey(rfcKey); got != rfcAccept {
+		t.Errorf("acceptKey(%q) = %q, want %q", rfcKey, got, rfcAccept)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(r *http.Request)
+		status int
+	}{
+		{"valid", func(r *http.Request) {}, 0},
+		{"same origin", func(r *http.Request) { r.Header.Set("Origin", "http://forum.test") }, 0},
+		{"header tokens", func(r *http.Request) { r.Header.Set("Connection", "keep-alive, Upgrade") }, 0},
+		{"post", func(r *http.Request) { r.Method = http.MethodPost }, http.StatusMethodNotAllowed},
+		{"no upgrade", func(r *http.Request) { r.Header.Del("Upgrade") }, http.StatusUpgradeRequired},
+		{"old version", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Version", "8") }, http.StatusUpgradeRequired},
+		{"short key", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Key", "c2hvcnQ=") }, http.StatusBadRequest},
+		{"other origin", func(r *http.Request) { r.Header.Set("Origin", "http://evil.test") }, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://forum.test/messages/ws", nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", rfcKey)
+		tt.change(r)
+		if status, msg := Check(r); status != tt.status {
+			t.Errorf("%s: Check = %d %q, want %d", tt.name, status, msg, tt.status)
+		}
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status, msg := Check(r); status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+		conn, err := Upgrade(w, r, 1024, time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage([]byte("hello"))
+	}))
+	defer server.Close()
+
+	client, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	handshake := "GET / HTTP/1.1\r\nHost: " + server.Listener.Addr().String() + "\r\n" +
+		"Connection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: " + rfcKey + "\r\n\r\n"
+	if _, err := io.WriteString(client, handshake); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(client)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != rfcAccept {
+		t.Errorf("Sec-WebSocket-Accept %q, want %q", got, rfcAccept)
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
+		t.Errorf("Upgrade %q, want websocket", resp.Header.Get("Upgrade"))
+	}
+	if op, payload := serverFrame(t, br); op != opText || string(payload) != "hello" {
+		t.Errorf("first message %#x %q, want text %q", op, payload, "hello")
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	c, client := pipe(t, 1024)
+	done := read(c)
+	send(client, clientFrame(true, opText, []byte("héllo"), true))
+	res := <-done
+	if res.err != nil || string(res.message) != "héllo" {
+		t.Errorf("ReadMessage = %q, %v, want %q", res.message, res.err, "héllo")
+	}
+}
+
+func TestRejectsUnmaskedFrame(t *testing.T) {
+	c, client := pipe(t, 1024)
+	done := read(c)
+	send(client, clientFrame(true, opText, []byte("hello"), false))
+	expectClose(t, client, done, CloseProtocolError)
+}
+
+func TestRejectsLongControlFrame(t *testing.T) {
+	c, client := pipe(t, 1024)
+	done := read(c)
+	send(client, clientFrame(true, opPing, make([]byte, 126), true))
+	expectClose(t, client, done, CloseProtocolError)
+}
+
+func TestRejectsFragmentedControlFrame(t *testing.T) {
+	c, client := pipe(t, 1024)
+	done := read(c)
+	send(client, clientFrame(false, opPing, []byte("ping"), true))
+	expectClose(t, client, done, CloseProtocolError)
+}
+
+func TestContinuation(t *testing.T) {
+	c, client := pipe(t, 1024)
+	done := read(c)
+	// a ping may come between the fragments, é is split across them
+	send(client,
+		clientFrame(false, opText, []byte("h\xc3"), true),
+		clientFrame(true, opPing, []byte("are you there"), true),
+		clientFrame(false, opContinuation, []byte("\xa9ll"), true),
+		clientFrame(true, opContinuation, []byte("o"), true),
+	)
+	if op, payload := serverFrame(t, client); op != opPong || string(payload) != "are you there" {
+		t.Errorf("answer to the ping %#x %q, want a pong with its payload", op, payload)
+	}
+	res := <-done
+	if res.err != nil || string(res.message) != "héllo" {
+		t.Errorf("ReadMessage = %q, %v, want %q", res.message, res.err, "héllo")
+	}
+}
+
+func TestContinuationWithoutMessage(t *testing.T) {
+	c, client := pipe(t, 1024)
+	done := read(c)
+	send(client, clientFrame(true, opContinuation, []byte("hello"), true))
+	expectClose(t, client, done, CloseProtocolError)
+}
+
+func TestNewMessageBeforeLastEnded(t *testing.T) {
+	c, client := pipe(t, 1024)
+	done := read(c)
+	send(client,
+		clientFrame(false, opText, []byte("hel"), true),
+		clientFrame(true, opText, []byte("lo"), true),
+	)
+	expectClose(t, client, done, CloseProtocolError)
+}
+
+func TestMaxSize(t *testing.T) {
+	t.Run("frame", func(t *testing.T) {
+		c, client := pipe(t, 8)
+		done := read(c)
+		send(client, clientFrame(true, opText, []byte("123456789"), true))
+		expectClose(t, client, done, CloseTooBig)
+	})
+	t.Run("fragments", func(t *testing.T) {
+		// every fragment fits, together they do not
+		c, client := pipe(t, 8)
+		done := read(c)
+		send(client,
+			clientFrame(false, opText, []byte("12345"), true),
+			clientFrame(true, opContinuation, []byte("6789"), true),
+		)
+		expectClose(t, client, done, CloseTooBig)
+	})
+	t.Run("fits", func(t *testing.T) {
+		c, client := pipe(t, 8)
+		done := read(c)
+		send(client,
+			clientFrame(false, opText, []byte("1234"), true),
+			clientFrame(true, opContinuation, []byte("5678"), true),
+		)
+		if res := <-done; res.err != nil || string(res.message) != "12345678" {
+			t.Errorf("ReadMessage = %q, %v, want %q", res.message, res.err, "12345678")
+		}
+	})
+}
+
+func TestRejectsInvalidUTF8(t *testing.T) {
+	c, client := pipe(t, 1024)
+	done := read(c)
+	send(client, clientFrame(true, opText, []byte("caf\xe9"), true))
+	expectClose(t, client, done, CloseInvalidData)
+}
+
+func TestRejectsBinary(t *testing.T) {
+	c, client := pipe(t, 1024)
+	done := read(c)
+	send(client, clientFrame(true, opBinary, []byte{1, 2, 3}, true))
+	expectClose(t, client, done, CloseUnsupported)
+}
+
+func TestCloseEcho(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		code    int
+	}{
+		{"normal", binary.BigEndian.AppendUint16(nil, CloseNormal), CloseNormal},
+		{"going away", binary.BigEndian.AppendUint16(nil, CloseGoingAway), CloseGoingAway},
+		{"application code", append(binary.BigEndian.AppendUint16(nil, 4001), "bye"...), 4001},
+		{"no code", nil, CloseNormal},
+		// codes that must not be sent are answered with a normal close
+		{"no status received", binary.BigEndian.AppendUint16(nil, 1005), CloseNormal},
+		{"below the range", binary.BigEndian.AppendUint16(nil, 999), CloseNormal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, client := pipe(t, 1024)
+			done := read(c)
+			send(client, clientFrame(true, opClose, tt.payload, true))
+			expectClose(t, client, done, tt.code)
+		})
+	}
+}
+
+func TestClosedConnection(t *testing.T) {
+	c, client := pipe(t, 1024)
+	done := read(c)
+	send(client, clientFrame(true, opClose, nil, true))
+	expectClose(t, client, done, CloseNormal)
+
+	if err := c.WriteMessage([]byte("late")); !errors.Is(err, ErrClosed) {
+		t.Errorf("WriteMessage after the close = %v, want ErrClosed", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("second Close = %v, want nil", err)
+	}
+}
@@ -2,11 +2,25 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"forum/server"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := server.RunMigrate(os.Args[2:]); err != nil {
+			log.Fatal("Migration error: ", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "role" {
+		if err := server.RunRole(os.Args[2:]); err != nil {
+			log.Fatal("Role error: ", err)
+		}
+		return
+	}
+
 	if err := server.Run(); err != nil {
 		log.Fatal("Server error:", err)
 	}
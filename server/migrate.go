@@ -0,0 +1,58 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+
+	db "forum/internal/database"
+)
+
+const migrateUsage = `usage: forum migrate <command>
+  status        list the migrations and when they were applied
+  up            apply every pending migration
+  to <version>  migrate up or down to the given version (0 reverts all)`
+
+// RunMigrate handles the "migrate" command line, used instead of starting the server
+func RunMigrate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(migrateUsage)
+	}
+
+	if err := db.OpenDatabase(dbPath); err != nil {
+		return err
+	}
+	defer db.DB.Close()
+
+	switch args[0] {
+	case "status":
+		status, err := db.MigrationsStatus()
+		if err != nil {
+			return err
+		}
+		for _, m := range status {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = "applied " + m.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-30s %s\n", m.Version, m.Name, applied)
+		}
+		return nil
+	case "up":
+		latest, err := db.LatestVersion()
+		if err != nil {
+			return err
+		}
+		return db.Migrate(latest)
+	case "to":
+		if len(args) != 2 {
+			return fmt.Errorf(migrateUsage)
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		return db.Migrate(version)
+	default:
+		return fmt.Errorf(migrateUsage)
+	}
+}
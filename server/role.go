@@ -0,0 +1,36 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	db "forum/internal/database"
+	"forum/internal/store"
+)
+
+const roleUsage = `usage: forum role <email> <user|moderator|admin>
+  gives the user with that email a role, like the first admin of a forum
+  whose users registered before roles existed`
+
+// RunRole handles the "role" command line, used instead of starting the server
+func RunRole(args []string) error {
+	if len(args) != 2 || !store.ValidRole(args[1]) {
+		return fmt.Errorf(roleUsage)
+	}
+
+	if err := db.InitDatabase(dbPath); err != nil {
+		return err
+	}
+	defer db.DB.Close()
+
+	users := store.NewSQLite(db.DB, db.SearchEnabled).Users
+	user, err := users.GetByEmail(strings.ToLower(strings.TrimSpace(args[0])))
+	if err != nil {
+		return fmt.Errorf("user %s: %w", args[0], err)
+	}
+	if err := users.SetRole(user.ID, args[1]); err != nil {
+		return err
+	}
+	fmt.Printf("%s (%s) is now %s\n", user.Username, user.Email, args[1])
+	return nil
+}
@@ -7,28 +7,96 @@ import (
 	"strings"
 
 	"forum/internal/auth"
+	"forum/internal/mail"
+	"forum/internal/store"
 
 	db "forum/internal/database"
 	H "forum/internal/handlers"
 )
 
-func NewRouter() *http.ServeMux {
+// NewRouter builds the handlers on top of the stores, the mailer and the login
+// providers and returns the router wrapped in the session and CSRF middlewares
+func NewRouter(stores *store.Stores, mailer mail.Mailer, providers []auth.Provider) http.Handler {
+	h := H.New(stores)
+	a := auth.New(stores, mailer, providers)
+
 	// initialize router
 	router := http.NewServeMux()
 
 	// public routes
-	router.HandleFunc("/", H.HomeHandler)
-	router.HandleFunc("/login", auth.LoginHandler)
-	router.HandleFunc("/register", auth.RegisterHandler)
-	router.HandleFunc("/logout", auth.LogoutHandler)
+	router.HandleFunc("/", h.HomeHandler)
+	router.HandleFunc("/login", a.LoginHandler)
+	router.HandleFunc("/login/two-factor", a.LoginTwoFactorHandler)
+	router.HandleFunc("/oauth/{provider}/login", a.OAuthLoginHandler)
+	router.HandleFunc("/oauth/{provider}/callback", a.OAuthCallbackHandler)
+	router.HandleFunc("/register", a.RegisterHandler)
+	router.HandleFunc("/logout", a.LogoutHandler)
+	router.HandleFunc("/forgot-password", a.ForgotPasswordHandler)
+	router.HandleFunc("/reset-password", a.ResetPasswordHandler)
+	router.HandleFunc("/verify-email", a.VerifyEmailHandler)
+	router.HandleFunc("/post", h.PostHandler)
+	router.HandleFunc("/search", h.SearchHandler)
+	router.HandleFunc("/events", h.EventsHandler)
 
 	// routes + middleware
-	router.Handle("/add-post", auth.RequireAuth(http.HandlerFunc(H.AddPostHandler)))
-	router.Handle("/add-comment", auth.RequireAuth(http.HandlerFunc(H.CommentHandler)))
-	router.Handle("/like-post", auth.RequireAuth(http.HandlerFunc(H.LikePostHandler)))
-	router.Handle("/dislike-post", auth.RequireAuth(http.HandlerFunc(H.DislikePostHandler)))
-	router.Handle("/like-comment", auth.RequireAuth(http.HandlerFunc(H.LikeCommentHandler)))
-	router.Handle("/dislike-comment", auth.RequireAuth(http.HandlerFunc(H.DislikeCommentHandler)))
+	router.Handle("/account", auth.RequireAuth(http.HandlerFunc(a.AccountHandler)))
+	router.Handle("/account/tokens", auth.RequireAuth(http.HandlerFunc(a.APITokensHandler)))
+	router.Handle("/account/tokens/revoke", auth.RequireAuth(http.HandlerFunc(a.RevokeAPITokenHandler)))
+	router.Handle("/revoke-session", auth.RequireAuth(http.HandlerFunc(a.RevokeSessionHandler)))
+	router.Handle("/logout-others", auth.RequireAuth(http.HandlerFunc(a.LogoutOthersHandler)))
+	router.Handle("/two-factor", auth.RequireAuth(http.HandlerFunc(a.TwoFactorHandler)))
+	router.Handle("/two-factor/enable", auth.RequireAuth(http.HandlerFunc(a.EnableTwoFactorHandler)))
+	router.Handle("/two-factor/disable", auth.RequireAuth(http.HandlerFunc(a.DisableTwoFactorHandler)))
+	router.Handle("/two-factor/recovery-codes", auth.RequireAuth(http.HandlerFunc(a.RecoveryCodesHandler)))
+	router.Handle("/oauth/unlink", auth.RequireAuth(http.HandlerFunc(a.UnlinkIdentityHandler)))
+	router.Handle("/resend-verification", auth.RequireAuth(http.HandlerFunc(a.ResendVerificationHandler)))
+	router.Handle("/admin/users", auth.RequireRole(store.RoleAdmin, http.HandlerFunc(a.AdminUsersHandler)))
+	router.Handle("/admin/set-role", auth.RequireRole(store.RoleAdmin, http.HandlerFunc(a.SetRoleHandler)))
+	router.Handle("/admin/categories", auth.RequireRole(store.RoleAdmin, http.HandlerFunc(h.AdminCategoriesHandler)))
+	router.Handle("/admin/categories/create", auth.RequireRole(store.RoleAdmin, http.HandlerFunc(h.CreateCategoryHandler)))
+	router.Handle("/admin/categories/update", auth.RequireRole(store.RoleAdmin, http.HandlerFunc(h.UpdateCategoryHandler)))
+	router.Handle("/admin/categories/move", auth.RequireRole(store.RoleAdmin, http.HandlerFunc(h.MoveCategoryHandler)))
+	router.Handle("/admin/categories/archive", auth.RequireRole(store.RoleAdmin, http.HandlerFunc(h.ArchiveCategoryHandler)))
+	router.Handle("/admin/categories/merge", auth.RequireRole(store.RoleAdmin, http.HandlerFunc(h.MergeCategoryHandler)))
+	router.Handle("/moderation", auth.RequireRole(store.RoleModerator, http.HandlerFunc(h.ModerationQueueHandler)))
+	router.Handle("/moderation/resolve", auth.RequireRole(store.RoleModerator, http.HandlerFunc(h.ResolveReportHandler)))
+	router.Handle("/moderation/unhide", auth.RequireRole(store.RoleModerator, http.HandlerFunc(h.UnhideHandler)))
+	router.Handle("/moderation/log", auth.RequireRole(store.RoleModerator, http.HandlerFunc(h.ModerationLogHandler)))
+	router.Handle("/moderation/users", auth.RequireRole(store.RoleModerator, http.HandlerFunc(a.ModerationUsersHandler)))
+	router.Handle("/moderation/ban", auth.RequireRole(store.RoleModerator, http.HandlerFunc(a.BanUserHandler)))
+	router.Handle("/moderation/lift", auth.RequireRole(store.RoleModerator, http.HandlerFunc(a.LiftBanHandler)))
+	router.Handle("/notifications", auth.RequireAuth(http.HandlerFunc(h.NotificationsHandler)))
+	router.Handle("/notifications/read", auth.RequireAuth(http.HandlerFunc(h.MarkNotificationReadHandler)))
+	router.Handle("/notifications/read-all", auth.RequireAuth(http.HandlerFunc(h.MarkAllNotificationsReadHandler)))
+	router.Handle("/messages", auth.RequireAuth(http.HandlerFunc(h.MessagesHandler)))
+	router.Handle("/messages/new", auth.RequireAuth(http.HandlerFunc(h.NewMessageHandler)))
+	router.Handle("/messages/ws", auth.RequireAuth(http.HandlerFunc(h.MessagesSocketHandler)))
+	router.Handle("/messages/block", auth.RequireAuth(http.HandlerFunc(h.BlockUserHandler)))
+	router.Handle("/messages/unblock", auth.RequireAuth(http.HandlerFunc(h.UnblockUserHandler)))
+	router.Handle("/conversation", auth.RequireAuth(http.HandlerFunc(h.ConversationHandler)))
+	router.Handle("/conversation/send", auth.RequireAuth(http.HandlerFunc(h.SendMessageHandler)))
+	router.Handle("/report", auth.RequireAuth(auth.RequireVerified(http.HandlerFunc(h.ReportHandler))))
+	router.Handle("/add-post", auth.RequireAuth(auth.RequireVerified(http.HandlerFunc(h.AddPostHandler))))
+	router.Handle("/edit-post", auth.RequireAuth(auth.RequireVerified(http.HandlerFunc(h.EditPostHandler))))
+	router.Handle("/delete-post", auth.RequireAuth(http.HandlerFunc(h.DeletePostHandler)))
+	router.Handle("/add-comment", auth.RequireAuth(auth.RequireVerified(http.HandlerFunc(h.CommentHandler))))
+	router.Handle("/edit-comment", auth.RequireAuth(auth.RequireVerified(http.HandlerFunc(h.EditCommentHandler))))
+	router.Handle("/delete-comment", auth.RequireAuth(http.HandlerFunc(h.DeleteCommentHandler)))
+	router.Handle("/like-post", auth.RequireAuth(http.HandlerFunc(h.LikePostHandler)))
+	router.Handle("/dislike-post", auth.RequireAuth(http.HandlerFunc(h.DislikePostHandler)))
+	router.Handle("/like-comment", auth.RequireAuth(http.HandlerFunc(h.LikeCommentHandler)))
+	router.Handle("/dislike-comment", auth.RequireAuth(http.HandlerFunc(h.DislikeCommentHandler)))
+
+	// JSON API, the handlers check the login themselves to answer with JSON errors
+	router.HandleFunc("/api/", h.APINotFoundHandler)
+	router.HandleFunc("/api/v1/categories", h.APICategoriesHandler)
+	router.HandleFunc("/api/v1/posts", h.APIPostsHandler)
+	router.HandleFunc("/api/v1/posts/{id}", h.APIPostHandler)
+	router.HandleFunc("/api/v1/posts/{id}/comments", h.APICommentsHandler)
+	router.HandleFunc("/api/v1/posts/{id}/reactions", h.APIPostReactionHandler)
+	router.HandleFunc("/api/v1/comments/{id}/reactions", h.APICommentReactionHandler)
+	router.HandleFunc("/api/v1/moderation/reports", h.APIReportsHandler)
+	router.HandleFunc("/api/v1/moderation/reports/{id}", h.APIReportHandler)
 
 	// static files handler plus checks for directories and ".." and forbids users from accessing
 	router.HandleFunc("/static/", func(w http.ResponseWriter, r *http.Request) {
@@ -47,5 +115,5 @@ func NewRouter() *http.ServeMux {
 		http.ServeFile(w, r, filePath)
 	})
 
-	return router
+	return a.AuthMiddleware(auth.CSRFMiddleware(router))
 }
@@ -8,16 +8,21 @@ import (
 	"time"
 
 	"forum/internal/auth"
+	"forum/internal/mail"
+	"forum/internal/store"
+
 	db "forum/internal/database"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+const dbPath = "forum.db"
+
 func Run() error {
 	flag.Parse()
 
 	//initialize database
-	if err := db.InitDatabase("forum.db"); err != nil {
+	if err := db.InitDatabase(dbPath); err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
 	defer db.DB.Close()
@@ -27,19 +32,38 @@ func Run() error {
 		log.Fatal("Failed to initialize templates:", err)
 	}
 
-	//startign session cleanup goroutine
+	stores := store.NewSQLite(db.DB, db.SearchEnabled)
+	mailer, err := mail.FromFlags()
+	if err != nil {
+		log.Fatal("Failed to set up mail:", err)
+	}
+
+	//startign session, login throttle and mailed token cleanup goroutine
+	limiter := &auth.Limiter{Throttles: stores.Throttles}
 	go func() {
 		for {
-			db.CleanSessions()
+			if err := stores.Sessions.DeleteExpired(); err != nil {
+				log.Printf("Failed to clean expired sessions: %v", err)
+			}
+			if err := limiter.Cleanup(); err != nil {
+				log.Printf("Failed to clean login throttles: %v", err)
+			}
+			if err := stores.Resets.DeleteExpired(time.Now()); err != nil {
+				log.Printf("Failed to clean password reset tokens: %v", err)
+			}
+			if err := stores.Verifications.DeleteExpired(time.Now()); err != nil {
+				log.Printf("Failed to clean email verification tokens: %v", err)
+			}
+			if err := stores.PendingLogins.DeleteExpired(time.Now()); err != nil {
+				log.Printf("Failed to clean pending 2FA logins: %v", err)
+			}
 			time.Sleep(1 * time.Hour)
 		}
 	}()
 
-	middlewareRouter := auth.AuthMiddleware(NewRouter())
-
 	server := &http.Server{
 		Addr:         ":8080",
-		Handler:      middlewareRouter,
+		Handler:      NewRouter(stores, mailer, auth.ProvidersFromFlags()),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,